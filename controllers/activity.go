@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/astaxie/beego"
+	"github.com/stefannaglee/docker-registry-manager/models/registry"
+)
+
+// defaultActivityLimit is the number of events returned by the activity API when no limit is passed
+const defaultActivityLimit = 500
+
+type ActivityController struct {
+	beego.Controller
+}
+
+// Get returns the template for the activity timeline of every registry, a registry or a repository
+func (c *ActivityController) Get() {
+
+	registryName := c.Ctx.Input.Param(":registryName")
+	repositoryName, _ := url.QueryUnescape(c.Ctx.Input.Param(":splat"))
+	if registryName != "" {
+		if _, ok := registry.Registries.GetByAlias(registryName); !ok {
+			c.CustomAbort(404, registryName+" was not found within the active list of registries.")
+			return
+		}
+	}
+
+	// The events are loaded by the template from GetEvents
+	c.Data["registryName"] = registryName
+	c.Data["repositoryName"] = repositoryName
+	c.Data["repositoryNameEncode"] = url.QueryEscape(repositoryName)
+	c.Data["registries"] = registry.Registries.List()
+	c.Data["actions"] = []string{registry.EventActionPush, registry.EventActionPull, registry.EventActionDelete}
+
+	// Index template
+	c.TplName = "activity.tpl"
+}
+
+// GetEvents responds with JSON containing the activity events matching the registry, repository, action,
+// actor and tag parameters, most recent first. since is a duration such as 24h or an RFC 3339 time.
+func (c *ActivityController) GetEvents() {
+
+	// Define the response
+	var res struct {
+		Events []registry.ActivityEvent `json:"events"`
+		Error  string                   `json:"error,omitempty"`
+	}
+
+	filter := registry.ActivityFilter{
+		Repository: c.GetString("repository"),
+		Action:     c.GetString("action"),
+		Actor:      c.GetString("actor"),
+		Tag:        c.GetString("tag"),
+	}
+	filter.Limit, _ = c.GetInt("limit", defaultActivityLimit)
+	if filter.Limit <= 0 {
+		filter.Limit = defaultActivityLimit
+	}
+
+	if registryName := c.GetString("registry"); registryName != "" {
+		r, ok := registry.Registries.GetByAlias(registryName)
+		if !ok {
+			c.Ctx.Output.SetStatus(404)
+			res.Error = registryName + " was not found within the active list of registries."
+			c.Data["json"] = &res
+			c.ServeJSON()
+			return
+		}
+		filter.Registry = r.ID
+	}
+
+	if since := c.GetString("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			filter.Since = t
+		} else {
+			c.Ctx.Output.SetStatus(400)
+			res.Error = "Invalid since " + since + ", expected a duration such as 24h or an RFC 3339 time"
+			c.Data["json"] = &res
+			c.ServeJSON()
+			return
+		}
+	}
+
+	res.Events = registry.Activity.List(filter)
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
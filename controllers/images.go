@@ -19,25 +19,28 @@ func (c *ImagesController) GetImages() {
 	tagName := c.Ctx.Input.Param(":tagName")
 	repositoryNameEncode := url.QueryEscape(repositoryName)
 
-	img, _ := registry.GetImage(registryName, repositoryName, tagName)
+	img, _ := registry.GetImage(c.Ctx.Request.Context(), registryName, repositoryName, tagName)
 
-	tagInfo, _ := registry.GetTag(registryName, repositoryName, tagName)
+	// Show the selected platform of a manifest list, or the default platform
+	selected := img.SelectPlatform(c.GetString("platform"))
+	tagInfo := registry.NewTagForView(tagName, selected)
+	tagInfo.Platforms = registry.NewTagForView(tagName, img).Platforms
 
-	activeRegistries := registry.ActiveRegistries
-	if _, ok := activeRegistries[registryName]; ok {
-		registry := activeRegistries[registryName]
-		c.Data["registry"] = registry
+	if r, ok := registry.Registries.GetByAlias(registryName); ok {
+		c.Data["registry"] = r
 	}
 
-	c.Data["containsV1Size"] = img.ContainsV1Size
-	c.Data["os"] = img.History[0].V1Compatibility.Os
-	c.Data["arch"] = img.History[0].V1Compatibility.Architecture
-	c.Data["history"] = img.History
+	c.Data["containsV1Size"] = selected.ContainsV1Size
+	c.Data["os"] = selected.OS
+	c.Data["arch"] = selected.Architecture
+	c.Data["platform"] = selected.PlatformName()
+	c.Data["digest"] = selected.Digest
+	c.Data["history"] = selected.History
 	c.Data["registryName"] = registryName
 	c.Data["repositoryName"] = repositoryName
 	c.Data["repositoryNameEncode"] = repositoryNameEncode
 	c.Data["tagInfo"] = tagInfo
-	c.Data["layers"] = img.FsLayers
+	c.Data["layers"] = selected.FsLayers
 
 	// Index template
 	c.TplName = "images.tpl"
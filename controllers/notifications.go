@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/astaxie/beego"
+	"github.com/stefannaglee/docker-registry-manager/models/registry"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// maxNotificationSize limits the size of an event envelope read from a registry
+const maxNotificationSize = 1 << 20
+
+type NotificationsController struct {
+	beego.Controller
+}
+
+// PostNotification receives the event envelopes a registry sends to its notification endpoints.
+// Events are matched to a registry by their source, or to the registry in the URL, and the
+// metadata index is updated in the background. Events from unknown registries or with unknown actions are skipped
+// rather than rejected so the registry doesn't keep retrying them.
+func (c *NotificationsController) PostNotification() {
+
+	// Define the response
+	var res struct {
+		Accepted int    `json:"accepted"`
+		Skipped  int    `json:"skipped"`
+		Error    string `json:"error,omitempty"`
+	}
+	fail := func(status int, err error) {
+		c.Ctx.Output.SetStatus(status)
+		res.Error = err.Error()
+		c.Data["json"] = &res
+		c.ServeJSON()
+	}
+
+	if err := registry.Authorize(c.Ctx.Input.Header("Authorization")); err != nil {
+		status := 401
+		if err == registry.ErrNotificationsDisabled {
+			status = 404
+		}
+		fail(status, err)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(c.Ctx.Input.Header("Content-Type")); mediaType != registry.MediaTypeEvents && mediaType != "application/json" {
+		fail(415, errors.New("Expected a "+registry.MediaTypeEvents+" event envelope"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(c.Ctx.Request.Body, maxNotificationSize))
+	if err != nil {
+		fail(400, err)
+		return
+	}
+	notification, err := registry.ParseNotification(body)
+	if err != nil {
+		fail(400, err)
+		return
+	}
+	res.Skipped = notification.Skipped
+
+	// Events sent to /registries/<alias>/notifications all belong to that registry
+	var bound *registry.Registry
+	if registryName := c.Ctx.Input.Param(":registryName"); registryName != "" {
+		r, ok := registry.Registries.GetByAlias(registryName)
+		if !ok {
+			c.CustomAbort(404, registryName+" was not found within the active list of registries.")
+			return
+		}
+		bound = &r
+	}
+
+	type match struct {
+		r registry.Registry
+		e registry.NotificationEvent
+	}
+	matched := []match{}
+	for _, e := range notification.Events {
+		var r registry.Registry
+		var ok bool
+		if bound != nil {
+			r, ok = *bound, true
+		} else {
+			r, ok = e.Registry()
+		}
+		if !ok {
+			utils.Log.WithFields(logrus.Fields{
+				"Event":      e.ID,
+				"Source":     e.Source.Addr,
+				"Host":       e.Request.Host,
+				"Repository": e.Target.Repository,
+			}).Warn("Skipping a notification from an unknown registry")
+			res.Skipped++
+			continue
+		}
+		matched = append(matched, match{r, e})
+		if a, ok := registry.NewActivityEvent(r, e); ok {
+			registry.Activity.Record(a)
+		}
+	}
+	res.Accepted = len(matched)
+
+	// Apply the events in the order they were sent without holding up the registry
+	go func() {
+		for _, m := range matched {
+			if err := registry.ApplyNotificationEvent(context.Background(), m.r, m.e); err != nil {
+				utils.Log.WithFields(logrus.Fields{
+					"Registry":   m.r.Alias,
+					"Event":      m.e.ID,
+					"Action":     m.e.Action,
+					"Repository": m.e.Target.Repository,
+					"Error":      err,
+				}).Error("Unable to apply a notification to the metadata index")
+			}
+		}
+	}()
+
+	c.Ctx.Output.SetStatus(202)
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
@@ -1,6 +1,12 @@
 package controllers
 
 import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/astaxie/beego"
 	"github.com/pivotal-golang/bytefmt"
 	"github.com/stefannaglee/docker-registry-manager/models/registry"
@@ -12,23 +18,42 @@ type RegistriesController struct {
 	beego.Controller
 }
 
-// Get returns the template for the registries page
-func (c *RegistriesController) Get() {
+// selectRegistries returns the active registries matching the label selector in the selector
+// parameter, e.g ?selector=env=prod. It aborts with a 400 if the selector can't be parsed.
+func selectRegistries(c *beego.Controller) []registry.Registry {
+	selector, err := registry.ParseLabelSelector(c.GetString("selector"))
+	if err != nil {
+		c.CustomAbort(400, err.Error())
+	}
+	c.Data["selector"] = selector.String()
+	return registry.Registries.Select(selector)
+}
 
-	for _, r := range registry.ActiveRegistries {
+// Get returns the template for the registries page, filtered by the selector parameter.
+// The counts come from the metadata index when it is enabled, otherwise every registry is asked for them.
+func (c *RegistriesController) Get() {
+	if registry.MetadataIndex != nil {
+		c.getIndexed()
+		return
+	}
 
-		// Get the repository count for this registry
-		repositories := registry.GetRepositories(r.Name)
-		r.RepoCount = len(repositories)
+	for _, r := range selectRegistries(&c.Controller) {
 
-		// For each registry update their status
-		r.UpdateRegistryStatus()
+		// Get the repository count for this registry, the status is kept up to date by the health monitor
+		repositories := registry.GetRepositories(c.Ctx.Request.Context(), r.Alias)
 
-		// Get the total size of the registry
+		// Get the total size of the registry. The size of a public registry isn't totalled
+		// since every manifest pulled counts against its rate limit, so only the tags are counted
 		var totalSize int64
 		var tagCount int
 		for _, repo := range repositories {
-			tags, _ := registry.GetTagsForView(r.Name, repo.Name)
+			if r.IsPublic() {
+				tags, _ := registry.GetTags(c.Ctx.Request.Context(), r.Alias, repo.Name)
+				tagCount += len(tags.Tags)
+				continue
+			}
+
+			tags, _ := registry.GetTagsForView(c.Ctx.Request.Context(), r.Alias, repo.Name)
 
 			tagCount += len(tags)
 			for _, t := range tags {
@@ -36,24 +61,135 @@ func (c *RegistriesController) Get() {
 			}
 
 		}
-		r.RepoTotalSizeStr = bytefmt.ByteSize(uint64(totalSize))
-		r.TagCount = tagCount
 
-		registry.ActiveRegistries[r.Name] = r
+		// The registry may have been edited or removed while we were talking to it
+		registry.Registries.Modify(r.ID, func(stored *registry.Registry) {
+			stored.RepoCount = len(repositories)
+			stored.TagCount = tagCount
+			stored.RepoTotalSize = totalSize
+			stored.RepoTotalSizeStr = bytefmt.ByteSize(uint64(totalSize))
+			if r.IsPublic() {
+				stored.RepoTotalSizeStr = "-"
+			}
+		})
+	}
+
+	registries := selectRegistries(&c.Controller)
+	health := make(map[string]registry.HealthSummary, len(registries))
+	for _, r := range registries {
+		health[r.ID] = registry.Health.Summary(r)
 	}
-	c.Data["registries"] = registry.ActiveRegistries
+	c.Data["registries"] = registries
+	c.Data["health"] = health
 
 	// Index template
 	c.TplName = "registries.tpl"
 }
 
-func (c *RegistriesController) GetRegistryCount() {
-	c.Data["registries"] = registry.ActiveRegistries
+// getIndexed renders the registries page with the counts from the last crawl of each registry
+func (c *RegistriesController) getIndexed() {
+	registries := selectRegistries(&c.Controller)
+	health := make(map[string]registry.HealthSummary, len(registries))
+	indexed := make(map[string]*registry.IndexedRegistry, len(registries))
+	for i, r := range registries {
+		health[r.ID] = registry.Health.Summary(r)
+
+		summary, ok := registry.MetadataIndex.Registry(r.ID)
+		if !ok {
+			continue
+		}
+		indexed[r.ID] = &summary
+		registries[i].RepoCount = summary.RepoCount
+		registries[i].TagCount = summary.TagCount
+		registries[i].RepoTotalSize = summary.Size
+		registries[i].RepoTotalSizeStr = summary.SizeStr()
+		if r.IsPublic() {
+			registries[i].RepoTotalSizeStr = "-"
+		}
+	}
+	c.Data["registries"] = registries
+	c.Data["health"] = health
+	c.Data["indexed"] = indexed
+	c.Data["indexing"] = true
+
+	// Index template
+	c.TplName = "registries.tpl"
+}
 
+// RefreshRegistry crawls the registry into the metadata index in the background and responds with JSON
+func (c *RegistriesController) RefreshRegistry() {
+	registryName := c.Ctx.Input.Param(":registryName")
+
+	// Define the response
+	var res struct {
+		Error string `json:"error,omitempty"`
+	}
+
+	r, ok := registry.Registries.GetByAlias(registryName)
+	switch {
+	case !ok:
+		res.Error = registryName + " was not found within the active list of registries."
+		c.Ctx.Output.SetStatus(404)
+	case registry.MetadataIndex == nil:
+		res.Error = "The metadata index is disabled"
+		c.Ctx.Output.SetStatus(409)
+	default:
+		if err := registry.MetadataIndex.RefreshInBackground(context.Background(), r); err != nil {
+			res.Error = err.Error()
+			c.Ctx.Output.SetStatus(409)
+		} else {
+			c.Ctx.Output.SetStatus(202)
+		}
+	}
+
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
+
+// GetHealth responds with JSON containing the status, latency and uptime of every registry matching the selector
+func (c *RegistriesController) GetHealth() {
+	health := []registry.HealthSummary{}
+	for _, r := range selectRegistries(&c.Controller) {
+		health = append(health, registry.Health.Summary(r))
+	}
+	c.Data["json"] = &health
+	c.ServeJSON()
+}
+
+// GetRegistryHealth responds with JSON containing the status and uptime of a registry,
+// and its status history over the window parameter which defaults to 24h
+func (c *RegistriesController) GetRegistryHealth() {
+	registryName := c.Ctx.Input.Param(":registryName")
+
+	r, ok := registry.Registries.GetByAlias(registryName)
+	if !ok {
+		c.CustomAbort(404, registryName+" was not found within the active list of registries.")
+		return
+	}
+
+	window, err := time.ParseDuration(c.GetString("window", "24h"))
+	if err != nil || window <= 0 {
+		c.CustomAbort(400, "The window must be a duration such as 24h")
+		return
+	}
+
+	res := struct {
+		registry.HealthSummary
+		History []registry.HealthCheck `json:"history"`
+	}{
+		registry.Health.Summary(r),
+		registry.Health.History(r.ID, window),
+	}
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
+
+// GetRegistryCount responds with JSON containing the number of active registries matching the selector
+func (c *RegistriesController) GetRegistryCount() {
 	registryCount := struct {
 		Count int
 	}{
-		len(registry.ActiveRegistries),
+		len(selectRegistries(&c.Controller)),
 	}
 	c.Data["json"] = &registryCount
 	c.ServeJSON()
@@ -61,54 +197,117 @@ func (c *RegistriesController) GetRegistryCount() {
 
 // AddRegistry adds a registry to the active registry list from a form
 func (c *RegistriesController) AddRegistry() {
-	host := c.GetString("host")
-	port := c.GetString("port")
-	scheme := c.GetString("scheme")
-	uri := scheme + "://" + host + ":" + port + "/v2"
+	r, err := c.parseRegistryForm()
+	if err != nil {
+		c.CustomAbort(400, err.Error())
+		return
+	}
+
+	err = r.UpdateRegistryStatus(c.Ctx.Request.Context())
+	if err != nil {
+		utils.Log.Error("Could not add registry " + r.GetURI())
+	} else if err := r.DetectCapabilities(c.Ctx.Request.Context()); err != nil {
+		utils.Log.Error("Could not detect the capabilities of registry " + r.GetURI())
+	}
+
+	if err := r.AddRegistry(); err != nil {
+		utils.Log.Error("Could not add registry " + r.GetURI() + ": " + err.Error())
+		c.CustomAbort(409, err.Error())
+		return
+	}
+	c.Ctx.Redirect(302, "/registries")
+}
 
-	// TODO: respond client with error
-	r, _ := registry.ParseRegistry(uri)
+// UpdateRegistry replaces the settings of a registry from the edit registry form
+func (c *RegistriesController) UpdateRegistry() {
+	registryName := c.Ctx.Input.Param(":registryName")
 
-	// Registry contains all identifying information for communicating with a registry
-	// TODO: respond to client with error
-	err := r.UpdateRegistryStatus()
+	old, ok := registry.Registries.GetByAlias(registryName)
+	if !ok {
+		c.CustomAbort(404, registryName+" was not found within the active list of registries.")
+		return
+	}
+
+	r, err := c.parseRegistryForm()
 	if err != nil {
-		utils.Log.Error("Could not add registry " + uri)
+		c.CustomAbort(400, err.Error())
+		return
+	}
+
+	// Credentials aren't rendered in the form, so keep the stored ones unless new ones were typed in
+	clear, _ := c.GetBool("clear_credentials")
+	if !clear && r.Username == "" && r.Password == "" {
+		r.Username = old.Username
+		r.Password = old.Password
+		r.CredentialsRef = old.CredentialsRef
+	}
+	if !clear && r.Proxy != "" && r.ProxyUsername == "" && r.ProxyPassword == "" {
+		r.ProxyUsername = old.ProxyUsername
+		r.ProxyPassword = old.ProxyPassword
+		r.ProxyCredentialsRef = old.ProxyCredentialsRef
 	}
 
-	r.AddRegistry()
+	err = r.UpdateRegistryStatus(c.Ctx.Request.Context())
+	if err != nil {
+		utils.Log.Error("Could not connect to updated registry " + r.GetURI())
+	} else if err := r.DetectCapabilities(c.Ctx.Request.Context()); err != nil {
+		utils.Log.Error("Could not detect the capabilities of registry " + r.GetURI())
+	}
+
+	if err := registry.Registries.Replace(old.ID, r); err != nil {
+		c.CustomAbort(409, err.Error())
+		return
+	}
 	c.Ctx.Redirect(302, "/registries")
 }
 
+// RemoveRegistry removes a registry from the active registries and responds with JSON
+func (c *RegistriesController) RemoveRegistry() {
+	registryName := c.Ctx.Input.Param(":registryName")
+
+	// Define the response
+	var res struct {
+		Error string `json:"error,omitempty"`
+	}
+
+	if r, ok := registry.Registries.GetByAlias(registryName); !ok {
+		res.Error = registryName + " was not found within the active list of registries."
+		c.Ctx.Output.SetStatus(404)
+	} else if _, err := registry.Registries.Remove(r.ID); err != nil {
+		res.Error = err.Error()
+		c.Ctx.Output.SetStatus(404)
+	}
+
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
+
 // TestRegistryStatus responds with JSON containing the status of the registry
 func (c *RegistriesController) TestRegistryStatus() {
 
 	// Define the response
 	var res struct {
-		Error       string `json:"error, omitempty"`
+		Error       string `json:"error,omitempty"`
 		IsAvailable bool   `json:"is_available"`
 	}
 
-	host := c.GetString("host")
-	port := c.GetString("port")
-	scheme := c.GetString("scheme")
-	uri := scheme + "://" + host + ":" + port + "/v2"
-
-	r, err := registry.ParseRegistry(uri)
+	r, err := c.parseRegistryForm()
 	if err != nil {
 		res.Error = err.Error()
 		res.IsAvailable = false
 		c.Data["json"] = &res
 		c.ServeJSON()
+		return
 	}
 
 	// Registry contains all identifying information for communicating with a registry
-	err = r.UpdateRegistryStatus()
+	err = r.UpdateRegistryStatus(c.Ctx.Request.Context())
 	if err != nil {
 		res.Error = err.Error()
 		res.IsAvailable = false
 		c.Data["json"] = &res
 		c.ServeJSON()
+		return
 	}
 
 	res.Error = ""
@@ -116,3 +315,50 @@ func (c *RegistriesController) TestRegistryStatus() {
 	c.Data["json"] = &res
 	c.ServeJSON()
 }
+
+// parseRegistryForm builds a registry from the fields of the add registry form
+func (c *RegistriesController) parseRegistryForm() (registry.Registry, error) {
+	host := strings.Trim(c.GetString("host"), "[]")
+	if port := c.GetString("port"); port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u := url.URL{
+		Scheme: c.GetString("scheme"),
+		Host:   host,
+		Path:   c.GetString("path"),
+	}
+
+	r, err := registry.ParseRegistry(u.String())
+	r.Alias = c.GetString("alias")
+	r.DisplayName = c.GetString("display_name")
+	labels, labelsErr := registry.ParseLabels(c.GetString("labels"))
+	if labelsErr != nil && err == nil {
+		err = labelsErr
+	}
+	r.Labels = labels
+
+	// Docker Hub is always public, other registries are public when chosen in the form
+	if kind := c.GetString("kind"); kind == registry.KindPublic {
+		r.Kind = kind
+	}
+	r.SetRepositories(registry.ParseRepositoryList(c.GetString("repositories")))
+
+	// Credentials and TLS settings are kept out of the URI so they don't need escaping
+	r.Username = c.GetString("username")
+	r.Password = c.GetString("password")
+	r.TLSCAFile = c.GetString("tls_ca")
+	r.TLSCertFile = c.GetString("tls_cert")
+	r.TLSKeyFile = c.GetString("tls_key")
+	r.TLSInsecureSkipVerify, _ = c.GetBool("tls_insecure")
+
+	// Credentials typed in the proxy URL take precedence over the proxy credential fields
+	r.ProxyUsername = c.GetString("proxy_username")
+	r.ProxyPassword = c.GetString("proxy_password")
+	if proxyErr := r.SetProxy(c.GetString("proxy")); proxyErr != nil && err == nil {
+		err = proxyErr
+	}
+
+	return r, err
+}
@@ -14,25 +14,30 @@ func (c *RepositoriesController) GetRepositories() {
 
 	registryName := c.Ctx.Input.Param(":registryName")
 
-	repositories := registry.GetRepositories(registryName)
-
-	for index, repo := range repositories {
-		tags, _ := registry.GetTags(registryName, repo.Name)
-		repositories[index].TagCount = len(tags.Tags)
+	// The repositories come from the metadata index once the registry has been crawled
+	repositories := registry.ListRepositories(c.Ctx.Request.Context(), registryName)
+
+	c.Data["canListCatalog"] = true
+	if r, ok := registry.Registries.GetByAlias(registryName); ok {
+		c.Data["canListCatalog"] = r.CanListRepositories()
+		if registry.MetadataIndex != nil {
+			if summary, ok := registry.MetadataIndex.Registry(r.ID); ok {
+				c.Data["indexed"] = &summary
+			}
+		}
 	}
+	c.Data["indexing"] = registry.MetadataIndex != nil
 	c.Data["registryName"] = registryName
 	c.Data["repositories"] = repositories
 	// Index template
 	c.TplName = "repositories.tpl"
 }
 
+// GetAllRepositoryCount responds with JSON containing the number of repositories in the registries matching the selector
 func (c *RepositoriesController) GetAllRepositoryCount() {
-	c.Data["registries"] = registry.ActiveRegistries
-
 	var count int
-	for _, reg := range registry.ActiveRegistries {
-		repositories := registry.GetRepositories(reg.Name)
-		count += len(repositories)
+	for _, reg := range selectRegistries(&c.Controller) {
+		count += registry.CountRepositories(c.Ctx.Request.Context(), reg.Alias)
 	}
 	repositoryCount := struct {
 		Count int
@@ -44,22 +49,15 @@ func (c *RepositoriesController) GetAllRepositoryCount() {
 	c.ServeJSON()
 }
 
-// GetAllRepositories returns the template for the all registries page
+// GetAllRepositories returns the template for the all registries page, filtered by the selector parameter
 func (c *RepositoriesController) GetAllRepositories() {
 
 	var allRepositories [][]registry.Repository
 
-	for _, reg := range registry.ActiveRegistries {
+	for _, reg := range selectRegistries(&c.Controller) {
 
-		// Get the list of all repositories
-		repositories := registry.GetRepositories(reg.Name)
-
-		// For each repository get the tags
-		for index, repo := range repositories {
-			tags, _ := registry.GetTags(reg.Name, repo.Name)
-			repositories[index].TagCount = len(tags.Tags)
-			repositories[index].Registry = reg.Name
-		}
+		// Get the list of all repositories with their tag counts
+		repositories := registry.ListRepositories(c.Ctx.Request.Context(), reg.Alias)
 		allRepositories = append(allRepositories, repositories)
 	}
 
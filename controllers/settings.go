@@ -1,10 +1,13 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 
 	"github.com/astaxie/beego"
 	"github.com/astaxie/beego/toolbox"
+	"github.com/stefannaglee/docker-registry-manager/models/registry"
 	"github.com/stefannaglee/docker-registry-manager/utilities"
 )
 
@@ -19,6 +22,56 @@ func (c *SettingsController) Get() {
 	c.TplName = "settings.tpl"
 }
 
+// ImportDockerConfig adds the registries of an uploaded docker config.json with their credentials
+// and responds with JSON containing the outcome for each registry
+func (c *SettingsController) ImportDockerConfig() {
+
+	// Define the response
+	var res struct {
+		Registries []registry.ImportResult `json:"registries"`
+		Error      string                  `json:"error,omitempty"`
+	}
+
+	file, _, err := c.GetFile("docker_config")
+	if err != nil {
+		c.Ctx.Output.SetStatus(400)
+		res.Error = "Choose a docker config.json to import"
+		c.Data["json"] = &res
+		c.ServeJSON()
+		return
+	}
+	defer file.Close()
+
+	body, err := ioutil.ReadAll(file)
+	if err == nil {
+		res.Registries, err = registry.ImportDockerConfig(c.Ctx.Request.Context(), body)
+	}
+	if err != nil {
+		c.Ctx.Output.SetStatus(400)
+		res.Error = err.Error()
+		c.Data["json"] = &res
+		c.ServeJSON()
+		return
+	}
+
+	// Check the registries that got new credentials straight away rather than waiting for the health monitor,
+	// the added ones were checked while they were imported
+	imported := []registry.Registry{}
+	for _, result := range res.Registries {
+		if r, ok := registry.Registries.GetByAlias(result.Alias); ok && result.Error == "" && result.Updated {
+			imported = append(imported, r)
+		}
+	}
+	go func() {
+		for _, r := range imported {
+			registry.Health.Check(context.Background(), r)
+		}
+	}()
+
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
+
 func (c *SettingsController) GetLogs() {
 	logs := utils.ParseLogFile()
 
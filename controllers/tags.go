@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"context"
 	"net/url"
 
 	"github.com/astaxie/beego"
 	"github.com/stefannaglee/docker-registry-manager/models/registry"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
 )
 
 type TagsController struct {
@@ -18,9 +20,14 @@ func (c *TagsController) GetTags() {
 	repositoryName, _ := url.QueryUnescape(c.Ctx.Input.Param(":splat"))
 	repositoryNameEncode := url.QueryEscape(repositoryName)
 
-	tags, _ := registry.GetTagsForView(registryName, repositoryName)
+	// Only offer deletes if the registry allows them
+	c.Data["canDelete"] = true
+	if r, ok := registry.Registries.GetByAlias(registryName); ok {
+		c.Data["canDelete"] = r.CanDelete()
+	}
 
-	c.Data["tags"] = tags
+	// Tags are loaded in pages by the template using GetTagsPage
+	c.Data["tagPageSize"] = registry.TagPageSize
 	c.Data["registryName"] = registryName
 	c.Data["repositoryNameEncode"] = repositoryNameEncode
 	c.Data["repositoryName"] = repositoryName
@@ -29,12 +36,65 @@ func (c *TagsController) GetTags() {
 	c.TplName = "tags.tpl"
 }
 
+// GetTagsPage responds with JSON containing a single page of tags so large repositories can be loaded in chunks
+func (c *TagsController) GetTagsPage() {
+
+	registryName := c.Ctx.Input.Param(":registryName")
+	repositoryName, _ := url.QueryUnescape(c.Ctx.Input.Param(":splat"))
+	n, err := c.GetInt("n")
+	if err != nil || n < 1 {
+		n = registry.TagPageSize
+	}
+	last := c.GetString("last")
+
+	// Define the response
+	var res struct {
+		Tags      registry.TagsForView `json:"tags"`
+		Next      string               `json:"next"`
+		Refreshed string               `json:"refreshed,omitempty"`
+		Error     string               `json:"error,omitempty"`
+	}
+
+	// Every tag is served in one page from the metadata index once the repository has been crawled
+	if tags, refreshed, ok := registry.GetIndexedTagsForView(registryName, repositoryName); ok {
+		res.Tags = tags
+		res.Refreshed = utils.TimeAgo(refreshed)
+		c.Data["json"] = &res
+		c.ServeJSON()
+		return
+	}
+
+	res.Tags, res.Next, err = registry.GetTagsForViewPage(c.Ctx.Request.Context(), registryName, repositoryName, n, last)
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	c.Data["json"] = &res
+	c.ServeJSON()
+}
+
 func (c *TagsController) DeleteTags() {
 	registryName := c.Ctx.Input.Param(":registryName")
 	repositoryName, _ := url.QueryUnescape(c.Ctx.Input.Param(":splat"))
 	tag := c.Ctx.Input.Param(":tagName")
 
-	registry.DeleteTag(registryName, repositoryName, tag)
+	if r, ok := registry.Registries.GetByAlias(registryName); ok && r.IsPublic() {
+		c.CustomAbort(405, registry.ErrReadOnly.Error())
+		return
+	} else if ok && !r.CanDelete() {
+		c.CustomAbort(405, registry.ErrDeleteDisabled.Error())
+		return
+	}
+
+	if _, err := registry.DeleteTag(c.Ctx.Request.Context(), registryName, repositoryName, tag); err != nil {
+		c.CustomAbort(500, err.Error())
+		return
+	}
+
+	// Update the metadata index so the deleted tag isn't shown until the next crawl
+	if r, ok := registry.Registries.GetByAlias(registryName); ok && registry.MetadataIndex != nil {
+		go registry.MetadataIndex.RefreshRepository(context.Background(), r, repositoryName)
+	}
 
 	c.CustomAbort(200, "Success")
 
@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ActivityLimit is the number of events the activity log keeps, the oldest are dropped first
+var ActivityLimit = 10000
+
+// Activity is the log of pushes, pulls and deletes in every registry
+var Activity = NewActivityLog()
+
+// Sources of an activity event
+const (
+	ActivitySourceNotification = "notification"
+	ActivitySourceManager      = "manager"
+)
+
+// activityBucket holds the events of a persisted activity log keyed by their sequence number
+var activityBucket = []byte("activity")
+
+// activityDedupWindow is how long after a delete made by the manager the registry's notification of it is ignored
+const activityDedupWindow = 5 * time.Minute
+
+// ActivityEvent is a push, pull or delete of a tag or manifest in a registry
+type ActivityEvent struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Registry   string    `json:"registry"`
+	Alias      string    `json:"alias"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor,omitempty"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	Source     string    `json:"source"`
+}
+
+// ActivityFilter selects events from the activity log, empty fields match every event
+type ActivityFilter struct {
+	Registry   string
+	Repository string
+	Action     string
+	Actor      string
+	Tag        string
+	Since      time.Time
+	Limit      int
+}
+
+// ActivityLog keeps the most recent ActivityLimit events in memory, and in a BoltDB file once opened.
+// It is safe for concurrent use.
+type ActivityLog struct {
+	sync.RWMutex
+	events []ActivityEvent
+	seq    uint64
+	db     *bolt.DB
+}
+
+// NewActivityLog creates an in memory ActivityLog which forgets the events of registries once they are removed
+func NewActivityLog() *ActivityLog {
+	l := &ActivityLog{}
+	Registries.Subscribe(func(e RegistryEvent) {
+		if e.Type == RegistryRemoved {
+			l.forget(e.Previous.ID)
+		}
+	})
+	return l
+}
+
+// Open persists the activity log to the BoltDB file at path, replacing the events in memory with the ones saved in it
+func (l *ActivityLog) Open(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+
+	events := []ActivityEvent{}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(activityBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			e := ActivityEvent{}
+			if json.Unmarshal(v, &e) == nil {
+				events = append(events, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.db = db
+	l.events = events
+	for _, e := range l.events {
+		if e.Seq > l.seq {
+			l.seq = e.Seq
+		}
+	}
+	l.trim()
+	return nil
+}
+
+// Close closes the BoltDB file of a persisted activity log, events are then only kept in memory
+func (l *ActivityLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.db == nil {
+		return nil
+	}
+	err := l.db.Close()
+	l.db = nil
+	return err
+}
+
+// Record adds an event to the log. A delete notified by the registry is ignored when the same
+// delete was just recorded by the manager, since the manager's event already names the tag.
+func (l *ActivityLog) Record(e ActivityEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if e.Source == ActivitySourceNotification && e.Action == EventActionDelete && l.recordedByManager(e) {
+		return
+	}
+
+	l.seq++
+	e.Seq = l.seq
+	l.events = append(l.events, e)
+	if l.db != nil {
+		err := l.db.Update(func(tx *bolt.Tx) error {
+			body, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket(activityBucket).Put(activityKey(e.Seq), body)
+		})
+		if err != nil {
+			utils.Log.WithFields(logrus.Fields{
+				"Registry": e.Alias,
+				"Error":    err,
+			}).Error("Unable to save an event to the activity log!")
+		}
+	}
+	l.trim()
+}
+
+// recordedByManager reports whether the delete was recorded by the manager within the dedup window
+func (l *ActivityLog) recordedByManager(e ActivityEvent) bool {
+	for i := len(l.events) - 1; i >= 0; i-- {
+		m := l.events[i]
+		if e.Time.Sub(m.Time) > activityDedupWindow {
+			return false
+		}
+		if m.Source == ActivitySourceManager && m.Action == EventActionDelete && m.Registry == e.Registry &&
+			m.Repository == e.Repository && m.Digest == e.Digest {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the events matching the filter, most recent first
+func (l *ActivityLog) List(f ActivityFilter) []ActivityEvent {
+	l.RLock()
+	defer l.RUnlock()
+
+	events := []ActivityEvent{}
+	for i := len(l.events) - 1; i >= 0; i-- {
+		e := l.events[i]
+		if f.Limit > 0 && len(events) >= f.Limit {
+			break
+		}
+		if !f.Since.IsZero() && e.Time.Before(f.Since) {
+			continue
+		}
+		if (f.Registry != "" && f.Registry != e.Registry) || (f.Repository != "" && f.Repository != e.Repository) ||
+			(f.Action != "" && f.Action != e.Action) || (f.Actor != "" && f.Actor != e.Actor) || (f.Tag != "" && f.Tag != e.Tag) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// forget drops every event of the registry
+func (l *ActivityLog) forget(registryID string) {
+	l.Lock()
+	defer l.Unlock()
+
+	kept := l.events[:0]
+	dropped := [][]byte{}
+	for _, e := range l.events {
+		if e.Registry == registryID {
+			dropped = append(dropped, activityKey(e.Seq))
+			continue
+		}
+		kept = append(kept, e)
+	}
+	l.events = kept
+	l.delete(dropped)
+}
+
+// trim drops the oldest events beyond ActivityLimit, the caller must hold the lock
+func (l *ActivityLog) trim() {
+	if ActivityLimit <= 0 || len(l.events) <= ActivityLimit {
+		return
+	}
+	dropped := [][]byte{}
+	for _, e := range l.events[:len(l.events)-ActivityLimit] {
+		dropped = append(dropped, activityKey(e.Seq))
+	}
+	l.events = append([]ActivityEvent{}, l.events[len(l.events)-ActivityLimit:]...)
+	l.delete(dropped)
+}
+
+// delete removes the keys from the BoltDB file, the caller must hold the lock
+func (l *ActivityLog) delete(keys [][]byte) {
+	if l.db == nil || len(keys) == 0 {
+		return
+	}
+	l.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(activityBucket)
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// activityKey orders the events of the BoltDB file by their sequence number
+func activityKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// NewActivityEvent builds the activity event for a notification from the registry. Only pushes and pulls
+// of manifests are kept, blob events would drown them out, and pulls made by the manager itself are left out.
+// Deletes are always kept since it can't be told whether they were for a manifest.
+func NewActivityEvent(r Registry, e NotificationEvent) (ActivityEvent, bool) {
+	switch e.Action {
+	case EventActionPush, EventActionPull:
+		if !e.IsManifest() || (e.Action == EventActionPull && strings.HasPrefix(e.Request.UserAgent, UserAgent)) {
+			return ActivityEvent{}, false
+		}
+	case EventActionDelete:
+	default:
+		return ActivityEvent{}, false
+	}
+
+	return ActivityEvent{
+		Time:       e.Timestamp,
+		Registry:   r.ID,
+		Alias:      r.Alias,
+		Action:     e.Action,
+		Actor:      e.Actor.Name,
+		Repository: r.NormalizeRepository(e.Target.Repository),
+		Tag:        e.Target.Tag,
+		Digest:     e.Target.Digest,
+		Source:     ActivitySourceNotification,
+	}, true
+}
@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestActivityLog checks that events are filtered, deduplicated, trimmed and kept across restarts
+func TestActivityLog(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "activity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "activity.db")
+
+	l := NewActivityLog()
+	if err := l.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	l.Record(ActivityEvent{Registry: "a", Action: EventActionPush, Repository: "app", Tag: "v1", Digest: "sha256:1", Source: ActivitySourceNotification})
+	l.Record(ActivityEvent{Registry: "b", Action: EventActionPull, Repository: "app", Tag: "v1", Source: ActivitySourceNotification})
+	l.Record(ActivityEvent{Registry: "a", Action: EventActionDelete, Repository: "app", Tag: "v1", Digest: "sha256:1", Source: ActivitySourceManager})
+
+	Convey("Events should be listed most recent first and filtered by every field", t, func() {
+		events := l.List(ActivityFilter{})
+		So(len(events), ShouldEqual, 3)
+		So(events[0].Action, ShouldEqual, EventActionDelete)
+
+		So(len(l.List(ActivityFilter{Registry: "a"})), ShouldEqual, 2)
+		So(len(l.List(ActivityFilter{Registry: "a", Action: EventActionPush})), ShouldEqual, 1)
+		So(len(l.List(ActivityFilter{Limit: 1})), ShouldEqual, 1)
+		So(len(l.List(ActivityFilter{Since: time.Now().Add(time.Hour)})), ShouldEqual, 0)
+	})
+
+	Convey("The registry's notification of a delete made by the manager should not be recorded twice", t, func() {
+		l.Record(ActivityEvent{Registry: "a", Action: EventActionDelete, Repository: "app", Digest: "sha256:1", Source: ActivitySourceNotification})
+		So(len(l.List(ActivityFilter{Action: EventActionDelete})), ShouldEqual, 1)
+	})
+
+	Convey("Events should be loaded again when the log is reopened", t, func() {
+		So(l.Close(), ShouldBeNil)
+		reopened := NewActivityLog()
+		So(reopened.Open(path), ShouldBeNil)
+		defer reopened.Close()
+		So(len(reopened.List(ActivityFilter{})), ShouldEqual, 3)
+
+		reopened.Record(ActivityEvent{Registry: "a", Action: EventActionPush, Repository: "app", Tag: "v2"})
+		So(reopened.List(ActivityFilter{})[0].Seq, ShouldEqual, 4)
+	})
+
+	Convey("Only the most recent ActivityLimit events should be kept", t, func() {
+		defer func(limit int) { ActivityLimit = limit }(ActivityLimit)
+		ActivityLimit = 2
+		trimmed := NewActivityLog()
+		for _, tag := range []string{"v1", "v2", "v3"} {
+			trimmed.Record(ActivityEvent{Registry: "a", Action: EventActionPush, Repository: "app", Tag: tag})
+		}
+		events := trimmed.List(ActivityFilter{})
+		So(len(events), ShouldEqual, 2)
+		So(events[1].Tag, ShouldEqual, "v2")
+	})
+
+	Convey("Pulls made by the manager and blob pushes should not become activity events", t, func() {
+		pull := NotificationEvent{Action: EventActionPull}
+		pull.Target.Repository = "app"
+		pull.Target.URL = "https://registry/v2/app/manifests/sha256:1"
+		_, ok := NewActivityEvent(Registry{}, pull)
+		So(ok, ShouldBeTrue)
+
+		pull.Request.UserAgent = UserAgent
+		_, ok = NewActivityEvent(Registry{}, pull)
+		So(ok, ShouldBeFalse)
+
+		blob := NotificationEvent{Action: EventActionPush}
+		blob.Target.URL = "https://registry/v2/app/blobs/sha256:2"
+		_, ok = NewActivityEvent(Registry{}, blob)
+		So(ok, ShouldBeFalse)
+	})
+}
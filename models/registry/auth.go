@@ -0,0 +1,235 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTokenExpiry is used when the token server does not return an expires_in value
+// https://docs.docker.com/registry/spec/auth/token/#requesting-a-token
+const defaultTokenExpiry = 60 * time.Second
+
+// AuthChallenge contains the information parsed from a WWW-Authenticate header
+type AuthChallenge struct {
+	Scheme  string
+	Realm   string
+	Service string
+	Scope   string
+}
+
+// Token contains the response from a registry token server
+type Token struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+	Expires     time.Time `json:"-"`
+}
+
+// tokenCache holds the bearer tokens for each registry and scope until they expire
+var tokenCache = struct {
+	sync.Mutex
+	tokens map[string]Token
+}{tokens: make(map[string]Token)}
+
+// fetchToken requests a new bearer token from the realm given in the challenge
+func (c *RegistryClient) fetchToken(ctx context.Context, challenge AuthChallenge) (Token, error) {
+
+	if challenge.Realm == "" {
+		return Token{}, errors.New("The WWW-Authenticate challenge did not contain a realm")
+	}
+
+	u, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return Token{}, err
+	}
+	q := u.Query()
+	if challenge.Service != "" {
+		q.Set("service", challenge.Service)
+	}
+	for _, scope := range strings.Fields(challenge.Scope) {
+		q.Add("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return Token{}, err
+	}
+	req = req.WithContext(ctx)
+
+	// The token server authenticates us with the registry credentials, anonymous otherwise
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	response, err := c.http.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return Token{}, errors.New("Token server responded with " + response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return parseToken(body)
+}
+
+// parseToken unmarshals a token server response and works out when the token expires
+func parseToken(body []byte) (Token, error) {
+	t := Token{}
+	if err := json.Unmarshal(body, &t); err != nil {
+		return t, err
+	}
+
+	// Some token servers only use the OAuth2 compatible access_token field
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	if t.Token == "" {
+		return t, errors.New("Token server response did not contain a token")
+	}
+
+	issued := t.IssuedAt
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+	expiry := defaultTokenExpiry
+	if t.ExpiresIn > 0 {
+		expiry = time.Duration(t.ExpiresIn) * time.Second
+	}
+	t.Expires = issued.Add(expiry)
+
+	return t, nil
+}
+
+// ParseAuthChallenge parses a WWW-Authenticate header value such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:samalba/my-app:pull"
+func ParseAuthChallenge(header string) (AuthChallenge, error) {
+	c := AuthChallenge{}
+
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return c, errors.New("Empty WWW-Authenticate header")
+	}
+
+	// The scheme is everything up to the first space
+	parts := strings.SplitN(header, " ", 2)
+	c.Scheme = parts[0]
+	if len(parts) == 1 {
+		return c, nil
+	}
+
+	// Walk the comma separated key="value" parameters, values may contain commas
+	params := parts[1]
+	for len(params) > 0 {
+		params = strings.TrimLeft(params, " ,")
+		eq := strings.Index(params, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(params[:eq]))
+		params = params[eq+1:]
+
+		var value string
+		if strings.HasPrefix(params, `"`) {
+			end := strings.Index(params[1:], `"`)
+			if end < 0 {
+				return c, errors.New("Unterminated quoted value in WWW-Authenticate header")
+			}
+			value = params[1 : end+1]
+			params = params[end+2:]
+		} else {
+			end := strings.Index(params, ",")
+			if end < 0 {
+				end = len(params)
+			}
+			value = strings.TrimSpace(params[:end])
+			params = params[end:]
+		}
+
+		switch key {
+		case "realm":
+			c.Realm = value
+		case "service":
+			c.Service = value
+		case "scope":
+			c.Scope = value
+		}
+	}
+
+	return c, nil
+}
+
+// requestScope returns the token scope a request to the passed path is expected to need
+// e.g /v2/myrepo/tags/list needs repository:myrepo:pull
+func requestScope(path string, method string) string {
+	if i := strings.Index(path+"/", "/v2/"); i >= 0 {
+		path = path[i+3:]
+	}
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return ""
+	}
+	if path == "_catalog" {
+		return "registry:catalog:*"
+	}
+
+	actions := "pull"
+	if method == "DELETE" {
+		actions = "delete"
+	}
+	for _, endpoint := range []string{"/tags/", "/manifests/", "/blobs/", "/referrers/"} {
+		if i := strings.LastIndex(path, endpoint); i > 0 {
+			return "repository:" + path[:i] + ":" + actions
+		}
+	}
+	return ""
+}
+
+// tokenKey identifies a cached token by registry URI, client and scope. The client key covers the
+// credentials, so registries sharing a URI with different users never use each other's tokens.
+func tokenKey(uri string, clientKey string, scope string) string {
+	return uri + " " + clientKey + " " + scope
+}
+
+func getCachedToken(key string) (Token, bool) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	t, ok := tokenCache.tokens[key]
+	if !ok || time.Now().After(t.Expires) {
+		return Token{}, false
+	}
+	return t, true
+}
+
+func setCachedToken(key string, t Token) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	tokenCache.tokens[key] = t
+}
+
+// clearCachedTokens removes every cached token of the client with the key for the registry URI
+func clearCachedTokens(uri string, clientKey string) {
+	prefix := tokenKey(uri, clientKey, "")
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	for key := range tokenCache.tokens {
+		if strings.HasPrefix(key, prefix) {
+			delete(tokenCache.tokens, key)
+		}
+	}
+}
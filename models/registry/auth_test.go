@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestParseAuthChallenge passes ParseAuthChallenge a bearer challenge and an empty header
+func TestParseAuthChallenge(t *testing.T) {
+
+	header := `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:samalba/my-app:pull,push"`
+	c, err := ParseAuthChallenge(header)
+	Convey("When we pass a bearer challenge we should get back the realm, service and scope", t, func() {
+		So(err, ShouldBeNil)
+		So(c.Scheme, ShouldEqual, "Bearer")
+		So(c.Realm, ShouldEqual, "https://auth.docker.io/token")
+		So(c.Service, ShouldEqual, "registry.docker.io")
+		So(c.Scope, ShouldEqual, "repository:samalba/my-app:pull,push")
+	})
+
+	_, err = ParseAuthChallenge("")
+	Convey("When we pass an empty header we should get back an error", t, func() {
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// TestRequestScope checks the scope we expect to need for each registry endpoint
+func TestRequestScope(t *testing.T) {
+
+	Convey("The scope should match the endpoint being requested", t, func() {
+		So(requestScope("/v2/", "GET"), ShouldEqual, "")
+		So(requestScope("/v2/_catalog", "GET"), ShouldEqual, "registry:catalog:*")
+		So(requestScope("/v2/library/ubuntu/tags/list", "GET"), ShouldEqual, "repository:library/ubuntu:pull")
+		So(requestScope("/v2/test/manifests/sha256:abc", "DELETE"), ShouldEqual, "repository:test:delete")
+	})
+}
+
+// TestParseToken checks that both token fields are accepted and the expiry is set
+func TestParseToken(t *testing.T) {
+
+	token, err := parseToken([]byte(`{"access_token":"abc","expires_in":300}`))
+	Convey("When the token server only returns an access_token we should still use it", t, func() {
+		So(err, ShouldBeNil)
+		So(token.Token, ShouldEqual, "abc")
+		So(token.Expires.IsZero(), ShouldBeFalse)
+	})
+
+	_, err = parseToken([]byte(`{}`))
+	Convey("When the token server returns no token we should get back an error", t, func() {
+		So(err, ShouldNotBeNil)
+	})
+}
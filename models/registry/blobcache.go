@@ -0,0 +1,295 @@
+package registry
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BlobCacheSize is the number of blob sizes kept by the blob cache, the least recently used are dropped first
+var BlobCacheSize = 100000
+
+// BlobSizes caches the size of every blob looked up by registry and digest. Blobs are content addressed
+// so their size never changes, and layers shared by many tags are only requested once.
+var BlobSizes = NewBlobCache()
+
+// blobSizesBucket holds the sizes of a persisted blob cache keyed by registry ID and digest
+var blobSizesBucket = []byte("blobs")
+
+// BlobCache is a bounded least recently used cache of blob sizes keyed by registry ID and digest,
+// kept in memory and in a BoltDB file once opened. It is safe for concurrent use.
+type BlobCache struct {
+	sync.Mutex
+	entries  map[string]*list.Element
+	order    *list.List
+	inflight map[string]*blobLookup
+	db       *bolt.DB
+}
+
+// blobEntry is a cached blob size
+type blobEntry struct {
+	key  string
+	size int64
+}
+
+// blobLookup is a request for a blob size that other callers wait on instead of sending their own
+type blobLookup struct {
+	done chan struct{}
+	size int64
+	err  error
+
+	// cancelled is set when the request failed because the context of its sender was done
+	cancelled bool
+}
+
+// NewBlobCache creates an in memory BlobCache which forgets the blobs of registries once they are removed
+func NewBlobCache() *BlobCache {
+	bc := &BlobCache{
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		inflight: make(map[string]*blobLookup),
+	}
+	Registries.Subscribe(func(e RegistryEvent) {
+		if e.Type == RegistryRemoved {
+			bc.forget(e.Previous.ID)
+		}
+	})
+	return bc
+}
+
+// Open persists the blob cache to the BoltDB file at path, loading the sizes already saved in it
+func (bc *BlobCache) Open(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+
+	bc.Lock()
+	defer bc.Unlock()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(blobSizesBucket)
+		if err != nil {
+			return err
+		}
+		err = b.ForEach(func(k, v []byte) error {
+			if len(v) == 8 {
+				bc.add(string(k), int64(binary.BigEndian.Uint64(v)))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Drop the sizes that didn't fit in the cache, the least recently used order isn't saved
+		stale := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			if _, ok := bc.entries[string(k)]; !ok {
+				stale = append(stale, append([]byte{}, k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	bc.db = db
+	return nil
+}
+
+// Close closes the BoltDB file of a persisted blob cache, sizes are then only kept in memory
+func (bc *BlobCache) Close() error {
+	bc.Lock()
+	defer bc.Unlock()
+	if bc.db == nil {
+		return nil
+	}
+	err := bc.db.Close()
+	bc.db = nil
+	return err
+}
+
+// Get returns the cached size of the blob
+func (bc *BlobCache) Get(registryID string, digest string) (int64, bool) {
+	bc.Lock()
+	defer bc.Unlock()
+	el, ok := bc.entries[blobKey(registryID, digest)]
+	if !ok {
+		return 0, false
+	}
+	bc.order.MoveToFront(el)
+	return el.Value.(*blobEntry).size, true
+}
+
+// Put caches the size of the blob, e.g from the layer descriptors of a manifest
+func (bc *BlobCache) Put(registryID string, digest string, size int64) {
+	if digest == "" || size < 0 {
+		return
+	}
+	key := blobKey(registryID, digest)
+
+	bc.Lock()
+	if el, ok := bc.entries[key]; ok {
+		bc.order.MoveToFront(el)
+		bc.Unlock()
+		return
+	}
+	dropped := bc.add(key, size)
+	db := bc.db
+	bc.Unlock()
+	if db == nil {
+		return
+	}
+
+	// Batch the writes of concurrent lookups, e.g while the tags of a repository are loaded
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(size))
+	err := db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(blobSizesBucket)
+		for _, k := range dropped {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(key), value)
+	})
+	if err != nil {
+		utils.Log.WithFields(logrus.Fields{
+			"Digest": digest,
+			"Error":  err,
+		}).Error("Unable to save a blob size to the blob cache!")
+	}
+}
+
+// Len returns the number of cached blob sizes
+func (bc *BlobCache) Len() int {
+	bc.Lock()
+	defer bc.Unlock()
+	return bc.order.Len()
+}
+
+// Size returns the size of a blob of the repository from the cache, or from a HEAD request to the
+// registry which is then cached. Concurrent lookups of the same blob share a single request, and the
+// callers waiting on a request cancelled by its sender send their own instead of failing with it.
+// HEAD /v2/<name>/blobs/<digest>
+func (bc *BlobCache) Size(ctx context.Context, c *RegistryClient, registryID string, repositoryName string, digest string) (int64, error) {
+	key := blobKey(registryID, digest)
+	for {
+		if size, ok := bc.Get(registryID, digest); ok {
+			return size, nil
+		}
+
+		bc.Lock()
+		if lookup, ok := bc.inflight[key]; ok {
+			bc.Unlock()
+			select {
+			case <-lookup.done:
+				if lookup.cancelled && ctx.Err() == nil {
+					continue
+				}
+				return lookup.size, lookup.err
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+		lookup := &blobLookup{done: make(chan struct{})}
+		bc.inflight[key] = lookup
+		bc.Unlock()
+
+		lookup.size, lookup.err = headBlob(ctx, c, repositoryName, digest)
+		if lookup.err == nil {
+			bc.Put(registryID, digest, lookup.size)
+		} else {
+			lookup.cancelled = ctx.Err() != nil
+		}
+
+		bc.Lock()
+		delete(bc.inflight, key)
+		bc.Unlock()
+		close(lookup.done)
+		return lookup.size, lookup.err
+	}
+}
+
+// headBlob requests the size of a blob from the registry
+func headBlob(ctx context.Context, c *RegistryClient, repositoryName string, digest string) (int64, error) {
+	response, err := c.Head(ctx, c.uri+"/"+repositoryName+"/blobs/"+digest)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.ContentLength < 0 {
+		return 0, errors.New("Blob request for " + digest + " returned " + response.Status)
+	}
+	return response.ContentLength, nil
+}
+
+// add caches the size and drops the least recently used sizes beyond BlobCacheSize, returning their keys.
+// The caller must hold the lock.
+func (bc *BlobCache) add(key string, size int64) []string {
+	bc.entries[key] = bc.order.PushFront(&blobEntry{key: key, size: size})
+
+	dropped := []string{}
+	for BlobCacheSize > 0 && bc.order.Len() > BlobCacheSize {
+		el := bc.order.Back()
+		entry := bc.order.Remove(el).(*blobEntry)
+		delete(bc.entries, entry.key)
+		dropped = append(dropped, entry.key)
+	}
+	return dropped
+}
+
+// forget drops the cached sizes of the registry
+func (bc *BlobCache) forget(registryID string) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	dropped := []string{}
+	prefix := registryID + keySeparator
+	for el := bc.order.Front(); el != nil; {
+		next := el.Next()
+		if entry := el.Value.(*blobEntry); strings.HasPrefix(entry.key, prefix) {
+			bc.order.Remove(el)
+			delete(bc.entries, entry.key)
+			dropped = append(dropped, entry.key)
+		}
+		el = next
+	}
+	bc.delete(dropped)
+}
+
+// delete removes the keys from the BoltDB file, the caller must hold the lock
+func (bc *BlobCache) delete(keys []string) {
+	if bc.db == nil || len(keys) == 0 {
+		return
+	}
+	bc.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(blobSizesBucket)
+		for _, k := range keys {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func blobKey(registryID string, digest string) string {
+	return registryID + keySeparator + digest
+}
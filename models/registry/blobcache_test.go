@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testManifestV1 = `{
+	"schemaVersion": 1,
+	"name": "legacy",
+	"tag": "old",
+	"architecture": "amd64",
+	"fsLayers": [
+		{"blobSum": "sha256:base"},
+		{"blobSum": "sha256:app"},
+		{"blobSum": "sha256:base"}
+	],
+	"history": [
+		{"v1Compatibility": "{\"id\": \"app\", \"os\": \"linux\"}"},
+		{"v1Compatibility": "{\"id\": \"base\"}"},
+		{"v1Compatibility": "{\"id\": \"root\"}"}
+	]
+}`
+
+// TestBlobCache checks that layer sizes are requested once per registry and digest
+func TestBlobCache(t *testing.T) {
+
+	var mu sync.Mutex
+	heads := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/legacy/manifests/old":
+			w.Header().Set("Content-Type", MediaTypeSignedManifestV1)
+			w.Write([]byte(testManifestV1))
+		case "/v2/legacy/blobs/sha256:base", "/v2/legacy/blobs/sha256:app":
+			mu.Lock()
+			heads[req.URL.Path]++
+			mu.Unlock()
+			w.Header().Set("Content-Length", "2048")
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	Convey("Layers shared within and across requests should only be requested once", t, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				GetImage(context.Background(), r.Alias, "legacy", "old")
+			}()
+		}
+		wg.Wait()
+
+		img, err := GetImage(context.Background(), r.Alias, "legacy", "old")
+		So(err, ShouldBeNil)
+		So(img.Size(), ShouldEqual, 3*2048)
+		So(heads["/v2/legacy/blobs/sha256:base"], ShouldEqual, 1)
+		So(heads["/v2/legacy/blobs/sha256:app"], ShouldEqual, 1)
+	})
+
+	Convey("Removing the registry should drop its blob sizes", t, func() {
+		Registries.Remove(r.ID)
+		_, ok := BlobSizes.Get(r.ID, "sha256:base")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+// TestBlobCacheCancel checks that callers sharing a blob request cancelled by its sender still get the size
+func TestBlobCacheCancel(t *testing.T) {
+
+	started := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		first := false
+		once.Do(func() { first = true })
+		if first {
+			// Hold the first request until its sender gives up
+			close(started)
+			<-req.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Length", "2048")
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewRegistryClient(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlobCache()
+
+	Convey("A waiting caller should send its own request when the shared one is cancelled", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		leader := make(chan error)
+		go func() {
+			_, err := bc.Size(ctx, c, r.ID, "repo", "sha256:shared")
+			leader <- err
+		}()
+		<-started
+
+		type result struct {
+			size int64
+			err  error
+		}
+		follower := make(chan result)
+		go func() {
+			size, err := bc.Size(context.Background(), c, r.ID, "repo", "sha256:shared")
+			follower <- result{size, err}
+		}()
+
+		// Give the follower time to wait on the request of the leader before cancelling it
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		So(<-leader, ShouldNotBeNil)
+
+		res := <-follower
+		So(res.err, ShouldBeNil)
+		So(res.size, ShouldEqual, 2048)
+		size, ok := bc.Get(r.ID, "sha256:shared")
+		So(ok, ShouldBeTrue)
+		So(size, ShouldEqual, 2048)
+	})
+}
+
+// TestBlobCacheBounds checks that the least recently used sizes are dropped and that sizes survive a restart
+func TestBlobCacheBounds(t *testing.T) {
+
+	defer func(size int) { BlobCacheSize = size }(BlobCacheSize)
+	BlobCacheSize = 2
+
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blobs.db")
+
+	bc := NewBlobCache()
+	if err := bc.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	bc.Put("registry", "sha256:a", 1)
+	bc.Put("registry", "sha256:b", 2)
+	bc.Get("registry", "sha256:a")
+	bc.Put("registry", "sha256:c", 3)
+
+	Convey("The least recently used size should be dropped once the cache is full", t, func() {
+		So(bc.Len(), ShouldEqual, 2)
+		_, ok := bc.Get("registry", "sha256:b")
+		So(ok, ShouldBeFalse)
+		size, ok := bc.Get("registry", "sha256:a")
+		So(ok, ShouldBeTrue)
+		So(size, ShouldEqual, 1)
+	})
+
+	Convey("Sizes should be loaded again when the cache is reopened", t, func() {
+		So(bc.Close(), ShouldBeNil)
+		reopened := NewBlobCache()
+		So(reopened.Open(path), ShouldBeNil)
+		defer reopened.Close()
+		So(reopened.Len(), ShouldEqual, 2)
+		size, ok := reopened.Get("registry", "sha256:c")
+		So(ok, ShouldBeTrue)
+		So(size, ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// ErrDeleteDisabled is returned when deleting from a registry that does not have deletes enabled
+var ErrDeleteDisabled = errors.New("The registry does not have deletes enabled, set REGISTRY_STORAGE_DELETE_ENABLED=true to allow them")
+
+// probeDigest is a digest no manifest has, it is used to probe endpoints without touching real content
+const probeDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// probeRepository is used to probe repository endpoints when the catalog can't be listed
+const probeRepository = "docker-registry-manager/probe"
+
+// Capabilities contains the features a registry was found to support the last time it was probed
+type Capabilities struct {
+	APIVersion string `json:"api_version"`
+	Catalog    bool   `json:"catalog"`
+	Delete     bool   `json:"delete"`
+	Referrers  bool   `json:"referrers"`
+
+	// Checked is when the registry was first found to have these capabilities
+	Checked time.Time `json:"checked"`
+}
+
+// Known reports whether the registry has been probed, until then every action is offered
+func (caps Capabilities) Known() bool {
+	return !caps.Checked.IsZero()
+}
+
+// Same reports whether both are known and have the same features, regardless of when they were checked
+func (caps Capabilities) Same(other Capabilities) bool {
+	if !caps.Known() || !other.Known() {
+		return false
+	}
+	caps.Checked, other.Checked = time.Time{}, time.Time{}
+	return caps == other
+}
+
+// CanDelete reports whether deletes should be offered for the registry
+func (caps Capabilities) CanDelete() bool {
+	return !caps.Known() || caps.Delete
+}
+
+// CanListCatalog reports whether the repositories of the registry can be listed
+func (caps Capabilities) CanListCatalog() bool {
+	return !caps.Known() || caps.Catalog
+}
+
+// refreshCapabilities probes the registry again and stores its capabilities if they changed,
+// e.g after a delete was refused because deletes were turned off
+func refreshCapabilities(ctx context.Context, r Registry) {
+	if err := r.DetectCapabilities(ctx); err != nil {
+		utils.Log.WithFields(logrus.Fields{
+			"Registry": r.Alias,
+			"Error":    err,
+		}).Warn("Unable to detect the registry capabilities")
+		return
+	}
+	Registries.Modify(r.ID, func(stored *Registry) {
+		if !r.Capabilities.Same(stored.Capabilities) {
+			stored.Capabilities = r.Capabilities
+		}
+	})
+}
+
+// DetectCapabilities probes the registry and sets its capabilities
+//
+// The API version comes from the Docker-Distribution-Api-Version header of the base route.
+// Deletes are probed by deleting a manifest that doesn't exist, registries with deletes
+// disabled respond with 405 Method Not Allowed. The referrers API is available when the
+// referrers of a missing manifest are listed with a 200, as the OCI distribution spec requires.
+func (r *Registry) DetectCapabilities(ctx context.Context) error {
+	c, err := r.Client()
+	if err != nil {
+		return err
+	}
+	caps := Capabilities{}
+
+	response, err := c.Get(ctx, r.GetURI()+"/")
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	caps.APIVersion = response.Header.Get("Docker-Distribution-Api-Version")
+
+	// Probe the repository endpoints with a real repository when there is one.
+	// Public registries are read only and have no catalog, so only their referrers are probed.
+	repository := probeRepository
+	if r.IsPublic() {
+		if len(r.Repositories) > 0 {
+			repository = r.Repositories[0]
+		}
+	} else if response, err := c.Get(ctx, r.GetURI()+"/_catalog?n=1"); err == nil {
+		page := RepositoriesList{}
+		if response.StatusCode == http.StatusOK && json.NewDecoder(response.Body).Decode(&page) == nil {
+			caps.Catalog = true
+			if len(page.Repositories) > 0 {
+				repository = page.Repositories[0]
+			}
+		}
+		response.Body.Close()
+	}
+
+	if !r.IsPublic() {
+		req, err := http.NewRequest("DELETE", r.GetURI()+"/"+repository+"/manifests/"+probeDigest, nil)
+		if err != nil {
+			return err
+		}
+		if response, err := c.Do(ctx, req); err == nil {
+			response.Body.Close()
+			switch response.StatusCode {
+			case http.StatusNotFound, http.StatusAccepted, http.StatusBadRequest:
+				caps.Delete = true
+			}
+		}
+	}
+
+	if response, err := c.Get(ctx, r.GetURI()+"/"+repository+"/referrers/"+probeDigest); err == nil {
+		response.Body.Close()
+		contentType := response.Header.Get("Content-Type")
+		caps.Referrers = response.StatusCode == http.StatusOK && strings.HasPrefix(contentType, MediaTypeOCIIndex)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	caps.Checked = time.Now()
+	r.Capabilities = caps
+	return nil
+}
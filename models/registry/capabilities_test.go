@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newCapabilitiesRegistry starts a registry that allows the catalog, and deletes and the referrers API as passed
+func newCapabilitiesRegistry(t *testing.T, deletes bool, referrers bool) (*httptest.Server, Registry) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Docker-Distribution-Api-Version", "registry/2.0")
+		switch {
+		case req.URL.Path == "/v2/":
+		case req.URL.Path == "/v2/_catalog":
+			w.Write([]byte(`{"repositories": ["library/alpine"]}`))
+		case req.Method == "DELETE" && req.URL.Path == "/v2/library/alpine/manifests/"+probeDigest:
+			if deletes {
+				http.NotFound(w, req)
+			} else {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case referrers && req.URL.Path == "/v2/library/alpine/referrers/"+probeDigest:
+			w.Header().Set("Content-Type", MediaTypeOCIIndex)
+			w.Write([]byte(`{"schemaVersion": 2, "manifests": []}`))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, r
+}
+
+// TestDetectCapabilities checks the API version, catalog, delete and referrers probes
+func TestDetectCapabilities(t *testing.T) {
+
+	Convey("A registry with deletes disabled is read only", t, func() {
+		server, r := newCapabilitiesRegistry(t, false, false)
+		defer server.Close()
+		So(r.Capabilities.CanDelete(), ShouldBeTrue)
+
+		So(r.DetectCapabilities(context.Background()), ShouldBeNil)
+		So(r.Capabilities.Known(), ShouldBeTrue)
+		So(r.Capabilities.APIVersion, ShouldEqual, "registry/2.0")
+		So(r.Capabilities.Catalog, ShouldBeTrue)
+		So(r.Capabilities.Delete, ShouldBeFalse)
+		So(r.Capabilities.CanDelete(), ShouldBeFalse)
+		So(r.Capabilities.Referrers, ShouldBeFalse)
+	})
+
+	Convey("A registry with deletes enabled and the referrers API supports both", t, func() {
+		server, r := newCapabilitiesRegistry(t, true, true)
+		defer server.Close()
+
+		So(r.DetectCapabilities(context.Background()), ShouldBeNil)
+		So(r.Capabilities.Delete, ShouldBeTrue)
+		So(r.Capabilities.Referrers, ShouldBeTrue)
+	})
+}
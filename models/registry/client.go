@@ -0,0 +1,287 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// ConnectTimeout is the time allowed to establish a connection and TLS session with a registry
+var ConnectTimeout = 10 * time.Second
+
+// ResponseTimeout is the time allowed for a registry to start responding to a request
+var ResponseTimeout = 30 * time.Second
+
+// UserAgent is sent with every request to a registry, so the pulls made by the manager can be told apart in its notifications
+const UserAgent = "docker-registry-manager"
+
+// RegistryClient makes the requests to a single registry over a pooled transport built from its settings.
+// It only holds what the requests need, the registry itself is read from Registries so it is never stale.
+type RegistryClient struct {
+	uri      string
+	key      string
+	username string
+	password string
+	http     *http.Client
+	breaker  breaker
+	limiter  rateLimiter
+}
+
+// clients holds the RegistryClient for each registry configuration so connections are shared between calls
+var clients = struct {
+	sync.Mutex
+	m map[string]*RegistryClient
+}{m: make(map[string]*RegistryClient)}
+
+// NewRegistryClient creates a client for the registry with its own pooled transport
+func NewRegistryClient(r Registry) (*RegistryClient, error) {
+	transport, err := newTransport(&r)
+	if err != nil {
+		return nil, err
+	}
+	return &RegistryClient{
+		uri:      r.GetURI(),
+		key:      r.clientKey(),
+		username: r.Username,
+		password: r.Password,
+		http:     &http.Client{Transport: transport},
+	}, nil
+}
+
+// Client returns the shared RegistryClient for this registry, creating it on first use
+func (r *Registry) Client() (*RegistryClient, error) {
+	key := r.clientKey()
+
+	clients.Lock()
+	defer clients.Unlock()
+	if c, ok := clients.m[key]; ok {
+		return c, nil
+	}
+
+	c, err := NewRegistryClient(*r)
+	if err != nil {
+		utils.Log.WithFields(logrus.Fields{
+			"Registry URI": r.GetURI(),
+			"Error":        err,
+		}).Error("Unable to build the TLS or proxy configuration for the registry!")
+		return nil, err
+	}
+	clients.m[key] = c
+	return c, nil
+}
+
+// clientKey identifies the settings a RegistryClient was built with, so changed settings get a new client.
+// The settings are hashed to keep the credentials out of the key.
+func (r *Registry) clientKey() string {
+	settings := strings.Join([]string{
+		r.GetURI(),
+		r.Username,
+		r.Password,
+		r.TLSCAFile,
+		r.TLSCertFile,
+		r.TLSKeyFile,
+		strconv.FormatBool(r.TLSInsecureSkipVerify),
+		r.Proxy,
+		r.ProxyUsername,
+		r.ProxyPassword,
+	}, "\x00")
+	sum := sha256.Sum256([]byte(settings))
+	return hex.EncodeToString(sum[:])
+}
+
+func init() {
+	// Forget the connections and tokens of registries that are removed or have new settings,
+	// unless another registry with the same settings still uses them
+	Registries.Subscribe(func(e RegistryEvent) {
+		if e.Type == RegistryRemoved || (e.Type == RegistryUpdated && e.Previous.clientKey() != e.Registry.clientKey()) {
+			if !clientInUse(e.Previous.clientKey()) {
+				forgetClient(&e.Previous)
+			}
+		}
+	})
+}
+
+// clientInUse reports whether an active registry uses the client with the key
+func clientInUse(key string) bool {
+	for _, r := range Registries.List() {
+		if r.clientKey() == key {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetClient closes the pooled connections of the registry client and drops its cached tokens
+func forgetClient(r *Registry) {
+	clients.Lock()
+	if c, ok := clients.m[r.clientKey()]; ok {
+		c.http.CloseIdleConnections()
+		delete(clients.m, r.clientKey())
+	}
+	clients.Unlock()
+
+	clearCachedTokens(r.GetURI(), r.clientKey())
+}
+
+// getClient returns the active registry with the passed alias and its client
+func getClient(registryAlias string) (Registry, *RegistryClient, error) {
+	r, ok := Registries.GetByAlias(registryAlias)
+	if !ok {
+		return r, nil, errors.New(registryAlias + " was not found within the active list of registries.")
+	}
+	c, err := r.Client()
+	return r, c, err
+}
+
+// Do executes the request against the registry, it is cancelled when the context is done.
+//
+// GET and HEAD requests that fail with a connection error or a 502, 503 or 504 are
+// retried with jittered exponential backoff. After BreakerThreshold requests in a row
+// have failed the registry is degraded, and requests fail fast with ErrRegistryDegraded
+// until BreakerCooldown has passed. Once the registry responds with 429 Too Many Requests,
+// requests fail fast with ErrRateLimited until its Retry-After has passed.
+func (c *RegistryClient) Do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	if !c.limiter.allow() {
+		return nil, ErrRateLimited
+	}
+	if !c.breaker.allow() {
+		return nil, ErrRegistryDegraded
+	}
+
+	attempts := 1
+	if retryable(req) && RetryAttempts > 1 {
+		attempts = RetryAttempts
+	}
+
+	var response *http.Response
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			utils.Log.WithFields(logrus.Fields{
+				"Registry URI": c.uri,
+				"Path":         req.URL.Path,
+				"Attempt":      attempt + 1,
+			}).Warn("Retrying request to registry")
+			if err := sleep(ctx, backoff(attempt-1)); err != nil {
+				c.breaker.release()
+				return nil, err
+			}
+		}
+
+		response, err = c.do(ctx, req)
+		if err == nil {
+			c.limiter.update(response)
+			if response.StatusCode == http.StatusTooManyRequests {
+				utils.Log.WithFields(logrus.Fields{
+					"Registry URI": c.uri,
+					"Path":         req.URL.Path,
+					"Paused Until": c.RateLimit().PausedUntil,
+				}).Warn("The registry is rate limiting requests, pausing requests to it")
+			}
+		}
+		if err == nil && !temporaryStatus(response.StatusCode) {
+			c.breaker.success()
+			return response, nil
+		}
+
+		// A cancelled request says nothing about the health of the registry
+		if ctx.Err() != nil {
+			c.breaker.release()
+			return response, err
+		}
+		if attempt < attempts-1 && response != nil {
+			response.Body.Close()
+		}
+	}
+
+	c.breaker.failure()
+	return response, err
+}
+
+// Degraded reports whether requests to the registry are failing fast after repeated failures
+func (c *RegistryClient) Degraded() bool {
+	return c.breaker.open()
+}
+
+// do sends the request once. Registries with credentials are sent them using HTTP Basic
+// auth. If the registry responds with a bearer challenge a scoped token is requested
+// from the realm, cached until it expires, and the request is retried with the token.
+// Requests with a body are only retried when it can be read again through GetBody,
+// otherwise the challenge is returned to the caller.
+func (c *RegistryClient) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	body := req.Body
+	req = req.Clone(ctx)
+	req.Header.Set("User-Agent", UserAgent)
+
+	// Use a cached token for this scope if we already have one
+	key := tokenKey(c.uri, c.key, requestScope(req.URL.Path, req.Method))
+	if t, ok := getCachedToken(key); ok {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	response, err := c.http.Do(req)
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	// Parse the challenge to find out where the token should come from
+	challenge, err := ParseAuthChallenge(response.Header.Get("WWW-Authenticate"))
+	replayable := body == nil || body == http.NoBody || req.GetBody != nil
+	if err != nil || !strings.EqualFold(challenge.Scheme, "bearer") || !replayable {
+		return response, nil
+	}
+	response.Body.Close()
+
+	t, err := c.fetchToken(ctx, challenge)
+	if err != nil {
+		utils.Log.WithFields(logrus.Fields{
+			"Registry URI": c.uri,
+			"Realm":        challenge.Realm,
+			"Scope":        challenge.Scope,
+			"Error":        err,
+		}).Error("Unable to get a bearer token from the registry token server!")
+		return nil, err
+	}
+	setCachedToken(key, t)
+
+	// Retry the request with the new token and a fresh copy of its body
+	retry := req.Clone(ctx)
+	if req.GetBody != nil && body != nil && body != http.NoBody {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+t.Token)
+
+	return c.http.Do(retry)
+}
+
+// Get executes a GET request for the passed uri against the registry
+func (c *RegistryClient) Get(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
+
+// Head executes a HEAD request for the passed uri against the registry
+func (c *RegistryClient) Head(ctx context.Context, uri string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(ctx, req)
+}
@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestRegistryClient checks that clients are shared per registry and requests stop when their context is cancelled
+func TestRegistryClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r, err := ParseRegistry(ts.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("The same client is returned for the same registry settings", t, func() {
+		a, err := r.Client()
+		So(err, ShouldBeNil)
+		b, err := r.Client()
+		So(err, ShouldBeNil)
+		So(a, ShouldEqual, b)
+
+		changed := r
+		changed.Username = "user"
+		c, err := changed.Client()
+		So(err, ShouldBeNil)
+		So(c, ShouldNotEqual, a)
+	})
+
+	Convey("The client and tokens of a removed registry are forgotten", t, func() {
+		So(r.AddRegistry(), ShouldBeNil)
+		a, err := r.Client()
+		So(err, ShouldBeNil)
+		setCachedToken(tokenKey(r.GetURI(), r.clientKey(), "registry:catalog:*"), Token{Token: "token", Expires: time.Now().Add(time.Minute)})
+
+		_, err = Registries.Remove(r.ID)
+		So(err, ShouldBeNil)
+		_, ok := getCachedToken(tokenKey(r.GetURI(), r.clientKey(), "registry:catalog:*"))
+		So(ok, ShouldBeFalse)
+		b, err := r.Client()
+		So(err, ShouldBeNil)
+		So(b, ShouldNotEqual, a)
+	})
+
+	Convey("A request with a cancelled context is not sent", t, func() {
+		c, err := r.Client()
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = c.Get(ctx, r.GetURI()+"/")
+		So(err, ShouldNotBeNil)
+
+		response, err := c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(response.StatusCode, ShouldEqual, http.StatusOK)
+	})
+}
+
+// TestRegistryClientLiveRegistry checks that requests read the registry from the store rather than from its shared client
+func TestRegistryClientLiveRegistry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"repositories": ["catalog"]}`))
+	}))
+	defer ts.Close()
+
+	r, err := ParseRegistry(ts.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Password = "secret"
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	Convey("The client key should not contain the credentials", t, func() {
+		So(r.clientKey(), ShouldNotContainSubstring, "secret")
+	})
+
+	Convey("Changes to a registry that keep its client settings should be seen by its requests", t, func() {
+		repos, err := GetRepositoriesFromRegistry(context.Background(), r.Alias)
+		So(err, ShouldBeNil)
+		So(repos.Repositories, ShouldResemble, []string{"catalog"})
+
+		err = Registries.Modify(r.ID, func(stored *Registry) {
+			stored.Kind = KindPublic
+			stored.SetRepositories([]string{"watched"})
+		})
+		So(err, ShouldBeNil)
+		repos, err = GetRepositoriesFromRegistry(context.Background(), r.Alias)
+		So(err, ShouldBeNil)
+		So(repos.Repositories, ShouldResemble, []string{"watched"})
+	})
+}
+
+// TestRegistryClientBearer checks that bearer tokens are cached per user and that retried requests keep their body and leave the caller's headers alone
+func TestRegistryClientBearer(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			user, _, _ := req.BasicAuth()
+			w.Write([]byte(`{"token": "token-` + user + `", "expires_in": 300}`))
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		if len(auth) < len("Bearer ") || auth[:len("Bearer ")] != "Bearer " {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write([]byte(auth[len("Bearer "):] + " " + string(body)))
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(r Registry, req *http.Request) (int, string) {
+		c, err := NewRegistryClient(r)
+		So(err, ShouldBeNil)
+		response, err := c.Do(context.Background(), req)
+		So(err, ShouldBeNil)
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	Convey("Registries sharing a URI with different users should not share tokens", t, func() {
+		alice, bob := r, r
+		alice.Username, alice.Password = "alice", "secret"
+		bob.Username, bob.Password = "bob", "secret"
+
+		for _, user := range []Registry{alice, bob, alice} {
+			req, err := http.NewRequest("GET", r.GetURI()+"/_catalog", nil)
+			So(err, ShouldBeNil)
+			status, body := send(user, req)
+			So(status, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "token-"+user.Username+" ")
+		}
+	})
+
+	Convey("A request with a body should be retried with the body and without changing the caller's headers", t, func() {
+		req, err := http.NewRequest("PUT", r.GetURI()+"/repo/manifests/latest", bytes.NewReader([]byte("manifest")))
+		So(err, ShouldBeNil)
+		status, body := send(r, req)
+		So(status, ShouldEqual, http.StatusOK)
+		So(body, ShouldEqual, "token- manifest")
+		So(req.Header.Get("Authorization"), ShouldEqual, "")
+		So(req.Header.Get("User-Agent"), ShouldEqual, "")
+	})
+
+	Convey("A request with a body that can't be read again should get the challenge back", t, func() {
+		req, err := http.NewRequest("PUT", r.GetURI()+"/other/manifests/latest", ioutil.NopCloser(bytes.NewReader([]byte("manifest"))))
+		So(err, ShouldBeNil)
+		status, _ := send(r, req)
+		So(status, ShouldEqual, http.StatusUnauthorized)
+	})
+}
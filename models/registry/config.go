@@ -0,0 +1,356 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// Config contains the registries saved in the configuration file
+type Config struct {
+	Registries []RegistryConfig `json:"registries"`
+}
+
+// RegistryConfig contains the saved settings of a single registry.
+//
+// Credentials are never written into the configuration file. Instead Credentials
+// references where the "user:password" pair is kept, either "env:NAME" for an
+// environment variable, "file:/path" for a file or "helper:NAME:SERVER" for the
+// docker-credential-NAME helper holding the credentials of the server.
+type RegistryConfig struct {
+	ID          string            `json:"id,omitempty"`
+	Alias       string            `json:"alias,omitempty"`
+	DisplayName string            `json:"displayName,omitempty"`
+	URL         string            `json:"url"`
+	Credentials string            `json:"credentials,omitempty"`
+	TLS         *TLSOptions       `json:"tls,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	// Kind is "public" for registries without a catalog, such as Docker Hub,
+	// which show the watched Repositories instead
+	Kind         string   `json:"kind,omitempty"`
+	Repositories []string `json:"repositories,omitempty"`
+
+	// Proxy is the proxy every request to the registry goes through
+	Proxy *ProxyOptions `json:"proxy,omitempty"`
+}
+
+// ProxyOptions contains the proxy settings of a saved registry. URL is an http, https or socks5 URL,
+// or "direct" to never use a proxy. Credentials is an env: or file: reference like the registry credentials.
+type ProxyOptions struct {
+	URL         string `json:"url"`
+	Credentials string `json:"credentials,omitempty"`
+}
+
+// TLSOptions contains the TLS settings of a saved registry
+type TLSOptions struct {
+	CAFile             string `json:"ca,omitempty"`
+	CertFile           string `json:"cert,omitempty"`
+	KeyFile            string `json:"key,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure,omitempty"`
+}
+
+// CredentialsInMemory is the reference of credentials that are only kept in memory and never saved,
+// e.g the ones imported from the auths of a docker config
+const CredentialsInMemory = "memory:"
+
+// configLock serializes writes to the configuration file
+var configLock sync.Mutex
+
+// LoadConfig reads the configuration file at path. A missing file is an empty configuration.
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(body, &config)
+	return config, err
+}
+
+// Registry builds the registry from its saved settings, resolving the credentials reference
+func (rc RegistryConfig) Registry() (Registry, error) {
+	r, err := ParseRegistry(rc.URL)
+	if err != nil {
+		return r, err
+	}
+
+	r.ID = rc.ID
+	r.Alias = rc.Alias
+	r.DisplayName = rc.DisplayName
+	r.Labels = rc.Labels
+	if rc.Kind != KindPrivate {
+		if rc.Kind != KindPublic {
+			return r, errors.New("Unknown registry kind " + rc.Kind + ", expected public or nothing for a private registry")
+		}
+		r.Kind = rc.Kind
+	}
+	if len(rc.Repositories) > 0 {
+		r.SetRepositories(rc.Repositories)
+	}
+	if rc.TLS != nil {
+		r.TLSCAFile = rc.TLS.CAFile
+		r.TLSCertFile = rc.TLS.CertFile
+		r.TLSKeyFile = rc.TLS.KeyFile
+		r.TLSInsecureSkipVerify = rc.TLS.InsecureSkipVerify
+	}
+
+	// Keep the references even if they can't be resolved so they are saved back unchanged
+	if rc.Proxy != nil {
+		if err := r.SetProxy(rc.Proxy.URL); err != nil {
+			return r, err
+		}
+		if rc.Proxy.Credentials != "" {
+			r.ProxyCredentialsRef = rc.Proxy.Credentials
+			r.ProxyUsername, r.ProxyPassword, err = ResolveCredentials(rc.Proxy.Credentials)
+			if err != nil {
+				return r, err
+			}
+		}
+	}
+	if rc.Credentials != "" {
+		r.CredentialsRef = rc.Credentials
+		r.Username, r.Password, err = ResolveCredentials(rc.Credentials)
+		if err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// MergeFlagRegistry returns the saved registry with the settings of the same registry passed with -registry
+// on top. Only the settings the flag actually sets are taken from it, so the saved credentials, TLS and
+// proxy settings aren't lost when the flag doesn't repeat them, and credentials the flag repeats keep
+// their saved reference.
+func MergeFlagRegistry(saved Registry, flag Registry) Registry {
+	r := saved
+	r.Scheme = flag.Scheme
+	r.Status = flag.Status
+	if (flag.Username != "" || flag.Password != "") && (flag.Username != saved.Username || flag.Password != saved.Password) {
+		r.Username = flag.Username
+		r.Password = flag.Password
+		r.CredentialsRef = ""
+	}
+	if flag.TLSCAFile != "" {
+		r.TLSCAFile = flag.TLSCAFile
+	}
+	if flag.TLSCertFile != "" || flag.TLSKeyFile != "" {
+		r.TLSCertFile = flag.TLSCertFile
+		r.TLSKeyFile = flag.TLSKeyFile
+	}
+	if flag.TLSInsecureSkipVerify {
+		r.TLSInsecureSkipVerify = true
+	}
+	if flag.Proxy != "" && (flag.Proxy != saved.Proxy || flag.ProxyUsername != saved.ProxyUsername || flag.ProxyPassword != saved.ProxyPassword) {
+		r.Proxy = flag.Proxy
+		r.ProxyUsername = flag.ProxyUsername
+		r.ProxyPassword = flag.ProxyPassword
+		r.ProxyCredentialsRef = ""
+	}
+	if flag.Kind != KindPrivate {
+		r.Kind = flag.Kind
+	}
+	if len(flag.Repositories) > 0 {
+		r.Repositories = flag.Repositories
+	}
+	return r
+}
+
+// ResolveCredentials returns the username and password held at an env:, file: or helper: credentials reference
+func ResolveCredentials(ref string) (string, string, error) {
+	var value string
+	switch {
+	case strings.HasPrefix(ref, "helper:"):
+		parts := strings.SplitN(strings.TrimPrefix(ref, "helper:"), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", "", errors.New("The credentials reference " + ref + " is not in the helper:NAME:SERVER form")
+		}
+		return CredentialHelper(parts[0], parts[1])
+	case strings.HasPrefix(ref, "env:"):
+		value = os.Getenv(strings.TrimPrefix(ref, "env:"))
+	case strings.HasPrefix(ref, "file:"):
+		body, err := ioutil.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", "", err
+		}
+		value = strings.TrimSpace(string(body))
+	default:
+		return "", "", errors.New("Unknown credentials reference " + ref + ", expected env:NAME, file:/path or helper:NAME:SERVER")
+	}
+
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return "", "", errors.New("The credentials at " + ref + " are not in the user:password form")
+	}
+	return value[:i], value[i+1:], nil
+}
+
+// NewRegistryConfig returns the settings of the registry to save. Credentials that were
+// entered in the UI are written to a file next to the configuration file and referenced.
+func NewRegistryConfig(r Registry, path string) (RegistryConfig, error) {
+	rc := RegistryConfig{
+		ID:           r.ID,
+		Alias:        r.Alias,
+		DisplayName:  r.DisplayName,
+		URL:          r.GetURI(),
+		Credentials:  r.CredentialsRef,
+		Labels:       r.Labels,
+		Kind:         r.Kind,
+		Repositories: r.Repositories,
+	}
+	if r.TLSCAFile != "" || r.TLSCertFile != "" || r.TLSKeyFile != "" || r.TLSInsecureSkipVerify {
+		rc.TLS = &TLSOptions{
+			CAFile:             r.TLSCAFile,
+			CertFile:           r.TLSCertFile,
+			KeyFile:            r.TLSKeyFile,
+			InsecureSkipVerify: r.TLSInsecureSkipVerify,
+		}
+	}
+
+	// Credentials only kept in memory are lost on restart, e.g until the docker config is imported again
+	var err error
+	if rc.Credentials == CredentialsInMemory {
+		rc.Credentials = ""
+	} else {
+		rc.Credentials, err = saveCredentials(credentialsFile(path, r.ID), rc.Credentials, r.Username, r.Password)
+		if err != nil {
+			return rc, err
+		}
+	}
+
+	if r.Proxy != "" {
+		rc.Proxy = &ProxyOptions{URL: r.Proxy}
+		rc.Proxy.Credentials, err = saveCredentials(proxyCredentialsFile(path, r.ID), r.ProxyCredentialsRef, r.ProxyUsername, r.ProxyPassword)
+		if err != nil {
+			return rc, err
+		}
+	}
+
+	return rc, nil
+}
+
+// saveCredentials writes credentials that weren't loaded from a reference to the file and returns the reference to save
+func saveCredentials(file string, ref string, username string, password string) (string, error) {
+	if username == "" || (ref != "" && ref != "file:"+file) {
+		return ref, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return ref, err
+	}
+	if err := saveFile(file, []byte(username+":"+password), 0600); err != nil {
+		return ref, err
+	}
+	return "file:" + file, nil
+}
+
+// SaveConfig writes every active registry to the configuration file at path, replacing it atomically
+func SaveConfig(path string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	config := Config{Registries: []RegistryConfig{}}
+	for _, r := range Registries.List() {
+		rc, err := NewRegistryConfig(r, path)
+		if err != nil {
+			return err
+		}
+		config.Registries = append(config.Registries, rc)
+	}
+
+	body, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	return saveFile(path, body, 0600)
+}
+
+// SaveConfigOnChange saves the configuration file at path whenever the active registries change
+func SaveConfigOnChange(path string) {
+	Registries.Subscribe(func(e RegistryEvent) {
+
+		// Remove the credentials files written for a registry that is gone or no longer uses them
+		if e.Previous.ID != "" {
+			file := credentialsFile(path, e.Previous.ID)
+			if e.Type == RegistryRemoved || e.Registry.Username == "" ||
+				(e.Registry.CredentialsRef != "" && e.Registry.CredentialsRef != "file:"+file) {
+				os.Remove(file)
+			}
+			file = proxyCredentialsFile(path, e.Previous.ID)
+			if e.Type == RegistryRemoved || e.Registry.Proxy == "" || e.Registry.ProxyUsername == "" ||
+				(e.Registry.ProxyCredentialsRef != "" && e.Registry.ProxyCredentialsRef != "file:"+file) {
+				os.Remove(file)
+			}
+		}
+
+		if err := SaveConfig(path); err != nil {
+			utils.Log.WithFields(logrus.Fields{
+				"Path":  path,
+				"Error": err,
+			}).Error("Unable to save the registry configuration file!")
+		}
+	})
+}
+
+// credentialsFile returns the file the credentials entered in the UI for a registry are saved to
+func credentialsFile(path string, registryID string) string {
+	return filepath.Join(filepath.Dir(path), "credentials", url.PathEscape(registryID))
+}
+
+// proxyCredentialsFile returns the file the proxy credentials entered in the UI for a registry are saved to
+func proxyCredentialsFile(path string, registryID string) string {
+	return credentialsFile(path, registryID) + ".proxy"
+}
+
+// saveFile atomically writes the file unless it already has the same contents
+func saveFile(path string, body []byte, perm os.FileMode) error {
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, body) {
+		return nil
+	}
+	return writeFileAtomic(path, body, perm)
+}
+
+// writeFileAtomic writes the file through a temporary file in the same directory which is then renamed,
+// so readers never see a partly written file
+func writeFileAtomic(path string, body []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,238 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// DockerConfig contains the registry credentials of a docker config.json, e.g ~/.docker/config.json
+type DockerConfig struct {
+	Auths       map[string]DockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+// DockerAuth contains the credentials of a single registry in a docker config.json.
+// Auth is the base64 encoded "user:password" pair written by docker login.
+type DockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// ImportResult contains the outcome of importing a single registry from a docker config.json
+type ImportResult struct {
+	ServerURL string `json:"server_url"`
+	Alias     string `json:"alias,omitempty"`
+	Updated   bool   `json:"updated"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CredentialHelper returns the username and secret the docker-credential-<helper> program holds for
+// the server. It can be replaced to import docker configs without running the helpers.
+var CredentialHelper = runCredentialHelper
+
+// validCredentialHelper matches the helper names which can only resolve to a docker-credential-<helper>
+// program on the PATH. Names come from uploaded configs, so path separators must never get through.
+var validCredentialHelper = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// runCredentialHelper runs docker-credential-<helper> get from the PATH with the server on stdin
+// https://github.com/docker/docker-credential-helpers#development
+func runCredentialHelper(helper string, serverURL string) (string, string, error) {
+	if !validCredentialHelper.MatchString(helper) {
+		return "", "", errors.New("The credential helper " + strconv.Quote(helper) + " is not a valid helper name")
+	}
+	path, err := exec.LookPath("docker-credential-" + helper)
+	if err != nil {
+		return "", "", errors.New("The credential helper docker-credential-" + helper + " was not found on the PATH")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Helpers print why they failed on stdout, e.g "credentials not found in native keychain"
+		msg := strings.TrimSpace(stdout.String() + " " + stderr.String())
+		return "", "", errors.New("docker-credential-" + helper + " failed for " + serverURL + ": " + msg)
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", errors.New("Unable to read the credentials from docker-credential-" + helper)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// ParseDockerConfig parses the contents of a docker config.json
+func ParseDockerConfig(body []byte) (DockerConfig, error) {
+	dc := DockerConfig{}
+	if err := json.Unmarshal(body, &dc); err != nil {
+		return dc, errors.New("Unable to parse the docker config: " + err.Error())
+	}
+	return dc, nil
+}
+
+// ServerURLs returns every server the docker config holds credentials for, sorted
+func (dc DockerConfig) ServerURLs() []string {
+	servers := []string{}
+	for server := range dc.Auths {
+		servers = append(servers, server)
+	}
+	for server := range dc.CredHelpers {
+		if _, ok := dc.Auths[server]; !ok {
+			servers = append(servers, server)
+		}
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+// Credentials returns the username and password for the server. A credential helper set for the
+// server in credHelpers comes first, then the auths entry, and then the credsStore for every server.
+func (dc DockerConfig) Credentials(server string) (string, string, error) {
+	if helper := dc.credentialHelper(server); helper != "" {
+		return CredentialHelper(helper, server)
+	}
+
+	auth := dc.Auths[server]
+	if auth.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", "", errors.New("The auth of " + server + " is not valid base64")
+		}
+		i := strings.Index(string(decoded), ":")
+		if i < 0 {
+			return "", "", errors.New("The auth of " + server + " is not in the user:password form")
+		}
+		return string(decoded[:i]), string(decoded[i+1:]), nil
+	}
+	if auth.Username != "" {
+		return auth.Username, auth.Password, nil
+	}
+	if auth.IdentityToken != "" {
+		return "", "", errors.New("The identity token of " + server + " can't be used, log in with a password or use a credential helper")
+	}
+	return "", "", nil
+}
+
+// credentialHelper returns the helper holding the credentials of the server, or nothing if they are in its auths entry
+func (dc DockerConfig) credentialHelper(server string) string {
+	if helper := dc.CredHelpers[server]; helper != "" {
+		return helper
+	}
+	auth := dc.Auths[server]
+	if auth.Auth != "" || auth.Username != "" || auth.IdentityToken != "" {
+		return ""
+	}
+	return dc.CredsStore
+}
+
+// credentialsRef returns the reference to save for the credentials of the server. Credentials held by a helper
+// are read from it again when the configuration is loaded, the ones in the auths are only kept in memory.
+func (dc DockerConfig) credentialsRef(server string) string {
+	if helper := dc.credentialHelper(server); helper != "" {
+		return "helper:" + helper + ":" + server
+	}
+	return CredentialsInMemory
+}
+
+// dockerConfigRegistry builds the registry for a docker config server, e.g registry.corp:5000
+// or https://index.docker.io/v1/. Docker logs in per host, so the path is not kept.
+func dockerConfigRegistry(server string) (Registry, error) {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return Registry{}, errors.New("Unable to parse the docker config server " + server)
+	}
+	return ParseRegistry(u.Scheme + "://" + u.Host + "/v2")
+}
+
+// ImportDockerConfig adds every registry listed in the docker config with its credentials.
+// Registries that are already active with the same host, port and path get the new credentials.
+func ImportDockerConfig(ctx context.Context, body []byte) ([]ImportResult, error) {
+	dc, err := ParseDockerConfig(body)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ImportResult{}
+	for _, server := range dc.ServerURLs() {
+		result := ImportResult{ServerURL: server}
+		r, err := importDockerConfigServer(ctx, dc, server, &result)
+		if err != nil {
+			result.Error = err.Error()
+			utils.Log.WithFields(logrus.Fields{
+				"Server": server,
+				"Error":  err,
+			}).Error("Unable to import a registry from the docker config!")
+		} else {
+			result.Alias = r.Alias
+			utils.Log.WithFields(logrus.Fields{
+				"Registry":     r.Alias,
+				"Registry URI": r.GetURI(),
+				"Updated":      result.Updated,
+			}).Info("Imported a registry from the docker config")
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// importDockerConfigServer adds or updates the registry of a single docker config server
+func importDockerConfigServer(ctx context.Context, dc DockerConfig, server string, result *ImportResult) (Registry, error) {
+	r, err := dockerConfigRegistry(server)
+	if err != nil {
+		return r, err
+	}
+	r.Username, r.Password, err = dc.Credentials(server)
+	if err != nil {
+		return r, err
+	}
+	if r.Username != "" {
+		r.CredentialsRef = dc.credentialsRef(server)
+	}
+
+	for _, active := range Registries.List() {
+		if active.Name == r.Name && active.Port == r.Port && active.Path == r.Path {
+			if r.Username == "" {
+				return active, nil
+			}
+			result.Updated = true
+			err = Registries.Modify(active.ID, func(stored *Registry) {
+				stored.Username = r.Username
+				stored.Password = r.Password
+				stored.CredentialsRef = r.CredentialsRef
+			})
+			return active, err
+		}
+	}
+
+	if err := r.UpdateRegistryStatus(ctx); err != nil {
+		utils.Log.Error("Could not connect to imported registry " + r.GetURI())
+	} else if err := r.DetectCapabilities(ctx); err != nil {
+		utils.Log.Error("Could not detect the capabilities of registry " + r.GetURI())
+	}
+
+	err = r.AddRegistry()
+	return r, err
+}
@@ -0,0 +1,145 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testDockerConfig = `{
+	"auths": {
+		"127.0.0.3:5000": {"auth": "dXNlcjpwYTpzcw=="},
+		"https://index.docker.io/v1/": {},
+		"127.0.0.4": {"identitytoken": "token"}
+	},
+	"credsStore": "desktop",
+	"credHelpers": {
+		"127.0.0.5": "corp"
+	}
+}`
+
+// TestImportDockerConfig checks that the auths are decoded, the helpers are called and every registry is added
+func TestImportDockerConfig(t *testing.T) {
+	helpers := map[string]string{}
+	CredentialHelper = func(helper string, serverURL string) (string, string, error) {
+		helpers[serverURL] = helper
+		if helper == "desktop" {
+			return "", "", errors.New("credentials not found in native keychain")
+		}
+		return "helper-user", "helper-secret", nil
+	}
+	defer func() { CredentialHelper = runCredentialHelper }()
+
+	results, err := ImportDockerConfig(context.Background(), []byte(testDockerConfig))
+	byServer := map[string]ImportResult{}
+	for _, result := range results {
+		byServer[result.ServerURL] = result
+		if r, ok := Registries.GetByAlias(result.Alias); ok && result.Alias != "" {
+			defer Registries.Remove(r.ID)
+		}
+	}
+
+	Convey("Every server in the docker config should be imported or report why it wasn't", t, func() {
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 4)
+		So(byServer["127.0.0.4"].Error, ShouldNotBeEmpty)
+		So(byServer["https://index.docker.io/v1/"].Error, ShouldContainSubstring, "keychain")
+	})
+
+	Convey("The base64 auth should be decoded into the registry credentials", t, func() {
+		r, ok := Registries.GetByAlias(byServer["127.0.0.3:5000"].Alias)
+		So(ok, ShouldBeTrue)
+		So(r.GetURI(), ShouldEqual, "https://127.0.0.3:5000/v2")
+		So(r.Username, ShouldEqual, "user")
+		So(r.Password, ShouldEqual, "pa:ss")
+	})
+
+	Convey("The credential helper for the server should be called before the credentials store", t, func() {
+		r, ok := Registries.GetByAlias(byServer["127.0.0.5"].Alias)
+		So(ok, ShouldBeTrue)
+		So(r.Username, ShouldEqual, "helper-user")
+		So(helpers["127.0.0.5"], ShouldEqual, "corp")
+		So(helpers["https://index.docker.io/v1/"], ShouldEqual, "desktop")
+	})
+
+	Convey("Imported registries should have their status checked like any other added registry", t, func() {
+		r, _ := Registries.GetByAlias(byServer["127.0.0.3:5000"].Alias)
+		So(r.Status, ShouldEqual, "unavailable")
+	})
+
+	Convey("Helper credentials should be saved as a reference to the helper and auths credentials not at all", t, func() {
+		dir, err := ioutil.TempDir("", "docker-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "registries.json")
+
+		r, _ := Registries.GetByAlias(byServer["127.0.0.5"].Alias)
+		rc, err := NewRegistryConfig(r, path)
+		So(err, ShouldBeNil)
+		So(rc.Credentials, ShouldEqual, "helper:corp:127.0.0.5")
+		username, _, err := ResolveCredentials(rc.Credentials)
+		So(err, ShouldBeNil)
+		So(username, ShouldEqual, "helper-user")
+
+		r, _ = Registries.GetByAlias(byServer["127.0.0.3:5000"].Alias)
+		rc, err = NewRegistryConfig(r, path)
+		So(err, ShouldBeNil)
+		So(rc.Credentials, ShouldBeEmpty)
+		_, err = os.Stat(credentialsFile(path, r.ID))
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+
+	Convey("Importing again should update the credentials of the registries already added", t, func() {
+		again, err := ImportDockerConfig(context.Background(), []byte(`{"auths": {"127.0.0.3:5000": {"username": "other", "password": "secret"}}}`))
+		So(err, ShouldBeNil)
+		So(again[0].Updated, ShouldBeTrue)
+		So(again[0].Alias, ShouldEqual, byServer["127.0.0.3:5000"].Alias)
+
+		r, _ := Registries.GetByAlias(again[0].Alias)
+		So(r.Username, ShouldEqual, "other")
+	})
+
+	Convey("A docker config that isn't JSON should be an error", t, func() {
+		_, err := ImportDockerConfig(context.Background(), []byte("auths"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// TestCredentialHelperName checks that helper names able to reach a program outside the PATH are refused
+func TestCredentialHelperName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helpers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Place a working helper where docker-credential-../x resolves from the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir("docker-credential-..", 0700); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho '{\"Username\": \"user\", \"Secret\": \"secret\"}'\n"
+	if err := ioutil.WriteFile(filepath.Join("docker-credential-..", "x"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("Helper names with path separators should be refused before looking up the program", t, func() {
+		for _, helper := range []string{"../x", "/bin/sh", "a/b", "..", "", "-x"} {
+			_, _, err := runCredentialHelper(helper, "127.0.0.5")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "is not a valid helper name")
+		}
+	})
+}
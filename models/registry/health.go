@@ -0,0 +1,209 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// HealthInterval is how often the health monitor probes each registry
+var HealthInterval = 30 * time.Second
+
+// HealthRetention is how long the status history of a registry is kept
+var HealthRetention = 7 * 24 * time.Hour
+
+// Health monitors the status of every active registry
+var Health = NewHealthMonitor()
+
+// HealthCheck contains the result of a single probe of a registry
+type HealthCheck struct {
+	Time    time.Time     `json:"time"`
+	Status  string        `json:"status"`
+	Latency time.Duration `json:"latency_ns"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HealthSummary contains the latest status of a registry and its uptime
+type HealthSummary struct {
+	Registry    string        `json:"registry"`
+	Status      string        `json:"status"`
+	Latency     time.Duration `json:"latency_ns"`
+	LatencyStr  string        `json:"latency"`
+	LastChecked time.Time     `json:"last_checked"`
+	LastError   string        `json:"last_error,omitempty"`
+	Uptime24h   float64       `json:"uptime_24h"`
+	Uptime7d    float64       `json:"uptime_7d"`
+}
+
+// HealthMonitor probes registries in the background and keeps the history of their status by registry ID
+type HealthMonitor struct {
+	sync.RWMutex
+	history map[string][]HealthCheck
+}
+
+// NewHealthMonitor creates a HealthMonitor which forgets the history of registries once they are removed
+func NewHealthMonitor() *HealthMonitor {
+	m := &HealthMonitor{history: make(map[string][]HealthCheck)}
+	Registries.Subscribe(func(e RegistryEvent) {
+		if e.Type == RegistryRemoved {
+			m.Lock()
+			delete(m.history, e.Previous.ID)
+			m.Unlock()
+		}
+	})
+	return m
+}
+
+// Run probes every registry straight away and then every interval until the context is done
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.CheckAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll probes every active registry concurrently and waits for the results
+func (m *HealthMonitor) CheckAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range Registries.List() {
+		wg.Add(1)
+		go func(r Registry) {
+			defer wg.Done()
+			m.Check(ctx, r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// Check probes the registry, records the result and sets the status of the registry in the store
+func (m *HealthMonitor) Check(ctx context.Context, r Registry) HealthCheck {
+	previous := r.Status
+
+	start := time.Now()
+	err := r.checkStatus(ctx)
+	check := HealthCheck{
+		Time:    start,
+		Status:  r.Status,
+		Latency: time.Since(start),
+	}
+	if err != nil {
+		check.Error = err.Error()
+	}
+
+	// A check cut short by shutting down says nothing about the registry
+	if ctx.Err() != nil {
+		return check
+	}
+	m.record(r.ID, check)
+
+	if check.Status != previous {
+		utils.Log.WithFields(logrus.Fields{
+			"Registry":        r.Alias,
+			"Registry URI":    r.GetURI(),
+			"Status":          check.Status,
+			"Previous Status": previous,
+			"Error":           check.Error,
+		}).Warn("Registry status changed")
+	}
+
+	// Keep track of what the registry supports while it is up, e.g deletes being turned on or off
+	if check.Status == "available" {
+		if err := r.DetectCapabilities(ctx); err != nil {
+			utils.Log.WithFields(logrus.Fields{
+				"Registry": r.Alias,
+				"Error":    err,
+			}).Warn("Unable to detect the registry capabilities")
+		}
+	}
+
+	// Subscribers such as the configuration file are only notified when the status or capabilities changed,
+	// so capabilities found to be the same as before are left as they were
+	Registries.Modify(r.ID, func(stored *Registry) {
+		stored.Status = check.Status
+		if r.Capabilities.Checked.After(stored.Capabilities.Checked) && !r.Capabilities.Same(stored.Capabilities) {
+			stored.Capabilities = r.Capabilities
+		}
+	})
+	return check
+}
+
+// record appends the check to the history of the registry and drops checks older than HealthRetention
+func (m *HealthMonitor) record(id string, check HealthCheck) {
+	m.Lock()
+	defer m.Unlock()
+
+	history := append(m.history[id], check)
+	cutoff := check.Time.Add(-HealthRetention)
+	i := 0
+	for i < len(history) && history[i].Time.Before(cutoff) {
+		i++
+	}
+	m.history[id] = history[i:]
+}
+
+// History returns the checks of the registry with the passed ID made within the window, oldest first
+func (m *HealthMonitor) History(id string, window time.Duration) []HealthCheck {
+	m.RLock()
+	defer m.RUnlock()
+
+	cutoff := time.Now().Add(-window)
+	history := []HealthCheck{}
+	for _, check := range m.history[id] {
+		if !check.Time.Before(cutoff) {
+			history = append(history, check)
+		}
+	}
+	return history
+}
+
+// Uptime returns the percentage of checks of the registry within the window that found it available
+func (m *HealthMonitor) Uptime(id string, window time.Duration) float64 {
+	history := m.History(id, window)
+	if len(history) == 0 {
+		return 0
+	}
+
+	available := 0
+	for _, check := range history {
+		if check.Status == "available" {
+			available++
+		}
+	}
+	return float64(available) * 100 / float64(len(history))
+}
+
+// Summary returns the latest status and uptime of the registry
+func (m *HealthMonitor) Summary(r Registry) HealthSummary {
+	s := HealthSummary{
+		Registry:  r.Alias,
+		Status:    r.Status,
+		Uptime24h: m.Uptime(r.ID, 24*time.Hour),
+		Uptime7d:  m.Uptime(r.ID, 7*24*time.Hour),
+	}
+
+	m.RLock()
+	if history := m.history[r.ID]; len(history) > 0 {
+		last := history[len(history)-1]
+		s.Status = last.Status
+		s.Latency = last.Latency
+		s.LatencyStr = last.Latency.Round(time.Millisecond).String()
+		s.LastChecked = last.Time
+		s.LastError = last.Error
+	}
+	m.RUnlock()
+
+	return s
+}
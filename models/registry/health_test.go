@@ -0,0 +1,130 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestHealthMonitor checks that probes set the registry status and are counted towards its uptime
+func TestHealthMonitor(t *testing.T) {
+	var up int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&up) == 1 {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	r, err := ParseRegistry(ts.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	Convey("A registry that stops responding with a 200 is unavailable", t, func() {
+		m := NewHealthMonitor()
+
+		check := m.Check(context.Background(), r)
+		So(check.Status, ShouldEqual, "available")
+		So(check.Error, ShouldBeEmpty)
+
+		atomic.StoreInt32(&up, 0)
+		check = m.Check(context.Background(), r)
+		So(check.Status, ShouldEqual, "unavailable")
+		So(check.Error, ShouldNotBeEmpty)
+
+		stored, _ := Registries.Get(r.ID)
+		So(stored.Status, ShouldEqual, "unavailable")
+
+		So(len(m.History(r.ID, time.Hour)), ShouldEqual, 2)
+		So(m.Uptime(r.ID, 24*time.Hour), ShouldEqual, 50)
+
+		summary := m.Summary(stored)
+		So(summary.Registry, ShouldEqual, r.Alias)
+		So(summary.Status, ShouldEqual, "unavailable")
+		So(summary.Uptime7d, ShouldEqual, 50)
+	})
+
+	Convey("Checks older than the retention are dropped", t, func() {
+		m := NewHealthMonitor()
+		m.record("old", HealthCheck{Time: time.Now().Add(-8 * 24 * time.Hour), Status: "available"})
+		m.record("old", HealthCheck{Time: time.Now(), Status: "unavailable"})
+		So(len(m.History("old", 30*24*time.Hour)), ShouldEqual, 1)
+		So(m.Uptime("old", 7*24*time.Hour), ShouldEqual, 0)
+	})
+}
+
+// TestHealthMonitorCapabilities checks that every check probes the capabilities and only changes are stored
+func TestHealthMonitorCapabilities(t *testing.T) {
+	var deletes, deleteEnabled int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "DELETE" {
+			atomic.AddInt32(&deletes, 1)
+			if atomic.LoadInt32(&deleteEnabled) == 1 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:manifest")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r, err := ParseRegistry(ts.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	updates := 0
+	Registries.Subscribe(func(e RegistryEvent) {
+		if e.Type == RegistryUpdated && e.Registry.ID == r.ID {
+			updates++
+		}
+	})
+
+	Convey("Unchanged capabilities are probed on every check without updating the registry", t, func() {
+		m := NewHealthMonitor()
+		m.Check(context.Background(), r)
+		stored, _ := Registries.Get(r.ID)
+		So(stored.Capabilities.Known(), ShouldBeTrue)
+		So(stored.Capabilities.Delete, ShouldBeFalse)
+		So(updates, ShouldEqual, 1)
+
+		m.Check(context.Background(), stored)
+		So(atomic.LoadInt32(&deletes), ShouldEqual, 2)
+		So(updates, ShouldEqual, 1)
+	})
+
+	Convey("Deletes turned on are seen on the next check", t, func() {
+		atomic.StoreInt32(&deleteEnabled, 1)
+		stored, _ := Registries.Get(r.ID)
+		NewHealthMonitor().Check(context.Background(), stored)
+		stored, _ = Registries.Get(r.ID)
+		So(stored.Capabilities.Delete, ShouldBeTrue)
+	})
+
+	Convey("A refused delete probes the capabilities again", t, func() {
+		atomic.StoreInt32(&deleteEnabled, 0)
+		_, err := DeleteTag(context.Background(), r.Alias, "test", "latest")
+		So(err, ShouldEqual, ErrDeleteDisabled)
+		stored, _ := Registries.Get(r.ID)
+		So(stored.Capabilities.Delete, ShouldBeFalse)
+	})
+}
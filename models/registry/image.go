@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -17,16 +18,26 @@ import (
 type Image struct {
 	Name           string
 	Tag            string
+	Digest         string
+	MediaType      string
 	SchemaVersion  int
 	Architecture   string
+	OS             string
+	Variant        string
 	TagID          uint
 	ContainsV1Size bool
 	History        []History `json:"history"`
-	FsLayers       []struct {
-		BlobSum string `json:"blobSum"`
-		Size    int64  `json:"-"`
-		SizeStr string `json:"-"`
-	} `json:"fsLayers"`
+	FsLayers       []FsLayer `json:"fsLayers"`
+
+	// Platforms contains an image for each platform of a manifest list or OCI index
+	Platforms []Image `json:"-"`
+}
+
+// FsLayer contains the digest and size of a single layer blob, ordered from the top layer down
+type FsLayer struct {
+	BlobSum string `json:"blobSum"`
+	Size    int64  `json:"-"`
+	SizeStr string `json:"-"`
 }
 
 // History contains the v1 compatibility string and marshaled json
@@ -101,43 +112,64 @@ type V1Compatibility struct {
 }
 
 // GetImage returns the image information for a given tag
-// HEAD /v2/<name>/manifests/<reference>
-/*
-	"name": <name>,
-	"tag": <tag>,
-	"fsLayers": [
-		 {
-				"blobSum": "<digest>"
-		 },
-		 ...
-	 ]
-	],
-	"history": <v1 images>,
-	"signature": <JWS>
-*/
-func GetImage(registryName string, repositoryName string, tagName string) (Image, error) {
+// GET /v2/<name>/manifests/<reference>
+//
+// The registry is asked for a manifest list, schema2 or OCI manifest first and
+// falls back to schema1 for older registries. All are converted into the same
+// Image type. Manifest lists and OCI indexes fill Platforms with an Image for
+// each platform, and the rest of the Image with the default platform.
+func GetImage(ctx context.Context, registryName string, repositoryName string, tagName string) (Image, error) {
 
 	// Check if the registry is listed as active
-	if _, ok := ActiveRegistries[registryName]; !ok {
-		return Image{}, errors.New(registryName + " was not found within the active list of registries.")
+	r, c, err := getClient(registryName)
+	if err != nil {
+		return Image{}, err
+	}
+
+	img, err := getImage(ctx, r, c, repositoryName, tagName)
+	if err != nil {
+		return Image{}, err
 	}
-	r := ActiveRegistries[registryName]
+	img.Tag = tagName
+
+	return img, nil
+}
 
-	// Create and execute Get request
-	response, err := http.Get(r.GetURI() + "/" + repositoryName + "/manifests/" + tagName)
+// getImage requests the manifest for the reference, which may be a tag or digest, and converts it into an Image.
+// The manifest is only fetched and parsed when it isn't in the manifest cache or its digest has changed.
+func getImage(ctx context.Context, r Registry, c *RegistryClient, repositoryName string, reference string) (Image, error) {
+	if img, ok := cachedImage(ctx, r, c, repositoryName, reference); ok {
+		countFetch(ctx, false)
+		img.Tag = reference
+		return img, nil
+	}
 
-	if response.StatusCode != 200 {
+	// Create and execute Get request, negotiating the manifest format
+	req, err := http.NewRequest("GET", r.GetURI()+"/"+repositoryName+"/manifests/"+reference, nil)
+	if err != nil {
+		return Image{}, err
+	}
+	req.Header.Set("Accept", strings.Join(ManifestAcceptTypes, ", "))
+	response, err := c.Do(ctx, req)
+	if err != nil {
 		utils.Log.WithFields(logrus.Fields{
-			"Error":       err,
-			"Status Code": response.StatusCode,
-			"Response":    response,
-		}).Error("Did not receive an ok status code!")
+			"Registry URL": string(r.GetURI()),
+			"Error":        err,
+			"Possible Fix": "Check to see if your registry is up, and serving on the correct port with 'docker ps'. ",
+		}).Error("Get request to registry failed for the manifests endpoint.")
 		return Image{}, err
 	}
 
 	// Close connection
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		utils.Log.WithFields(logrus.Fields{
+			"Status Code": response.StatusCode,
+		}).Error("Did not receive an ok status code!")
+		return Image{}, errors.New("Manifest request returned " + response.Status)
+	}
+
 	// Read response into byte body
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
@@ -147,18 +179,87 @@ func GetImage(registryName string, repositoryName string, tagName string) (Image
 		}).Error("Unable to read response into body!")
 		return Image{}, err
 	}
-	img := Image{}
-	if err := json.Unmarshal(body, &img); err != nil {
+
+	img := Image{
+		Name:      repositoryName,
+		Tag:       reference,
+		Digest:    response.Header.Get("Docker-Content-Digest"),
+		MediaType: manifestMediaType(response.Header.Get("Content-Type"), body),
+	}
+
+	switch img.MediaType {
+	case MediaTypeManifestList, MediaTypeOCIIndex:
+		err = parseManifestList(ctx, r, c, repositoryName, body, &img)
+	case MediaTypeManifestV2, MediaTypeOCIManifest:
+		err = parseManifestV2(ctx, r, c, repositoryName, body, &img)
+	default:
+		err = parseManifestV1(ctx, r, c, repositoryName, body, &img)
+	}
+	if err != nil {
 		utils.Log.WithFields(logrus.Fields{
-			"Error": err,
-		}).Error("Unable to unmarshal JSON!")
+			"Error":      err,
+			"Media Type": img.MediaType,
+		}).Error("Unable to parse the image manifest!")
 		return Image{}, err
 	}
+	countFetch(ctx, true)
+	Manifests.put(r.ID, repositoryName, reference, img, response.Header.Get("ETag"))
+
+	return img, nil
+}
+
+// PlatformName returns the platform of the image in the os/arch/variant form
+func (img Image) PlatformName() string {
+	name := img.OS + "/" + img.Architecture
+	if img.Variant != "" {
+		name += "/" + img.Variant
+	}
+	return name
+}
+
+// Size returns the total size of the image layers
+func (img Image) Size() int64 {
+	var size int64
+	for _, layer := range img.FsLayers {
+		size += layer.Size
+	}
+	return size
+}
+
+// SelectPlatform returns the image for the passed os/arch/variant platform from a manifest list.
+// The image itself is returned if it is not a manifest list or the platform is not in it.
+func (img Image) SelectPlatform(platform string) Image {
+	for _, p := range img.Platforms {
+		if p.PlatformName() == platform {
+			return p
+		}
+	}
+	return img
+}
+
+// parseManifestV1 fills the image from a schema1 manifest
+/*
+	"name": <name>,
+	"tag": <tag>,
+	"fsLayers": [
+		 {
+				"blobSum": "<digest>"
+		 },
+		 ...
+	 ]
+	],
+	"history": <v1 images>,
+	"signature": <JWS>
+*/
+func parseManifestV1(ctx context.Context, r Registry, c *RegistryClient, repositoryName string, body []byte, img *Image) error {
+	if err := json.Unmarshal(body, img); err != nil {
+		return err
+	}
 
 	// V1 compatibility is an escape string, so convert it to JSON and then update the key
 	for index, v1 := range img.History {
 		v1JSON := V1Compatibility{}
-		err = json.Unmarshal([]byte(v1.V1CompatibilityStr), &v1JSON)
+		err := json.Unmarshal([]byte(v1.V1CompatibilityStr), &v1JSON)
 		if err != nil {
 			utils.Log.Error(err)
 		}
@@ -170,27 +271,49 @@ func GetImage(registryName string, repositoryName string, tagName string) (Image
 		}
 
 		// Get first 8 characters for the short ID
-		v1JSON.IDShort = v1JSON.ID[0:7]
+		v1JSON.IDShort = shortID(v1JSON.ID)
 
 		// Remove shell command
-		v1JSON.ContainerConfig.CmdClean = strings.Replace(v1JSON.ContainerConfig.Cmd[0], "/bin/sh -c #(nop)", "", -1)
+		if len(v1JSON.ContainerConfig.Cmd) > 0 {
+			v1JSON.ContainerConfig.CmdClean = cleanCmd(v1JSON.ContainerConfig.Cmd[0])
+		}
 
 		img.History[index].V1Compatibility = v1JSON
 	}
 
-	// Update each FsLayer size
-	for index, layer := range img.FsLayers {
+	// The top layer holds the platform of the image
+	if len(img.History) > 0 {
+		img.OS = img.History[0].V1Compatibility.Os
+		if img.Architecture == "" {
+			img.Architecture = img.History[0].V1Compatibility.Architecture
+		}
+	}
 
-		// Check if the registry is listed as active
-		r := ActiveRegistries[registryName]
-		// Create and execute Get request
-		response, _ := http.Head(r.GetURI() + "/" + repositoryName + "/blobs/" + layer.BlobSum)
+	// Update each FsLayer size, schema1 manifests do not contain the sizes.
+	// Layers shared with other tags are usually in the blob cache already.
+	for index, layer := range img.FsLayers {
+		size, err := BlobSizes.Size(ctx, c, r.ID, repositoryName, layer.BlobSum)
 		if err != nil {
 			utils.Log.Error(err)
+			continue
 		}
-		img.FsLayers[index].Size = response.ContentLength
-		img.FsLayers[index].SizeStr = bytefmt.ByteSize(uint64(response.ContentLength))
+		img.FsLayers[index].Size = size
+		img.FsLayers[index].SizeStr = bytefmt.ByteSize(uint64(size))
 	}
 
-	return img, nil
+	return nil
+}
+
+// shortID returns the first characters of an image or layer ID for display
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 7 {
+		return id[0:7]
+	}
+	return id
+}
+
+// cleanCmd removes the shell prefix added by docker build from a history command
+func cleanCmd(cmd string) string {
+	return strings.Replace(cmd, "/bin/sh -c #(nop)", "", -1)
 }
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testManifestV2 = `{
+	"schemaVersion": 2,
+	"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+	"config": {"mediaType": "application/vnd.docker.container.image.v1+json", "size": 100, "digest": "sha256:config"},
+	"layers": [
+		{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": 1000, "digest": "sha256:base"},
+		{"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "size": 50, "digest": "sha256:top"}
+	]
+}`
+
+const testImageConfig = `{
+	"architecture": "arm64",
+	"os": "linux",
+	"created": "2016-05-01T10:00:00Z",
+	"history": [
+		{"created": "2016-04-01T10:00:00Z", "created_by": "/bin/sh -c #(nop) ADD file:abc in /"},
+		{"created": "2016-04-01T10:00:01Z", "created_by": "/bin/sh -c #(nop) CMD [\"sh\"]", "empty_layer": true},
+		{"created": "2016-05-01T10:00:00Z", "created_by": "/bin/sh -c apk add git"}
+	]
+}`
+
+const testManifestList = `{
+	"schemaVersion": 2,
+	"mediaType": "application/vnd.docker.distribution.manifest.list.v2+json",
+	"manifests": [
+		{"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "size": 500, "digest": "sha256:arm64", "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}},
+		{"mediaType": "application/vnd.oci.image.manifest.v1+json", "size": 500, "digest": "sha256:attestation", "platform": {"architecture": "unknown", "os": "unknown"}}
+	]
+}`
+
+// newTestRegistry starts a registry that serves a schema2 manifest for test:latest and a manifest list
+// for test:multi, and adds it to the active registries
+func newTestRegistry(t *testing.T) (*httptest.Server, Registry) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/test/manifests/latest":
+			w.Header().Set("Content-Type", MediaTypeManifestV2)
+			w.Header().Set("Docker-Content-Digest", "sha256:manifest")
+			w.Write([]byte(testManifestV2))
+		case "/v2/test/manifests/multi":
+			w.Header().Set("Content-Type", MediaTypeManifestList)
+			w.Write([]byte(testManifestList))
+		case "/v2/test/manifests/sha256:arm64":
+			w.Header().Set("Content-Type", MediaTypeManifestV2)
+			w.Write([]byte(testManifestV2))
+		case "/v2/test/blobs/sha256:config":
+			w.Write([]byte(testImageConfig))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	return server, r
+}
+
+// TestGetImageManifestV2 checks that a schema2 manifest is converted using its config blob and layer descriptors
+func TestGetImageManifestV2(t *testing.T) {
+
+	server, r := newTestRegistry(t)
+	defer server.Close()
+	defer Registries.Remove(r.ID)
+
+	img, err := GetImage(context.Background(), r.Alias, "test", "latest")
+	Convey("When the registry serves a schema2 manifest we should get back the image from the config blob", t, func() {
+		So(err, ShouldBeNil)
+		So(img.MediaType, ShouldEqual, MediaTypeManifestV2)
+		So(img.Digest, ShouldEqual, "sha256:manifest")
+		So(img.OS, ShouldEqual, "linux")
+		So(img.Architecture, ShouldEqual, "arm64")
+	})
+
+	Convey("The layers should be ordered from the top down with their sizes from the descriptors", t, func() {
+		So(len(img.FsLayers), ShouldEqual, 2)
+		So(img.FsLayers[0].BlobSum, ShouldEqual, "sha256:top")
+		So(img.FsLayers[0].Size, ShouldEqual, 50)
+		So(img.FsLayers[1].Size, ShouldEqual, 1000)
+	})
+
+	Convey("The history should be ordered from the top down and matched with the layers", t, func() {
+		So(len(img.History), ShouldEqual, 3)
+		So(img.History[0].V1Compatibility.ID, ShouldEqual, "sha256:top")
+		So(img.History[0].V1Compatibility.ContainerConfig.CmdClean, ShouldEqual, "/bin/sh -c apk add git")
+		So(img.History[1].V1Compatibility.ID, ShouldEqual, "")
+		So(img.History[2].V1Compatibility.ID, ShouldEqual, "sha256:base")
+	})
+}
+
+// TestGetImageManifestList checks that each platform of a manifest list is requested and the attestations are skipped
+func TestGetImageManifestList(t *testing.T) {
+
+	server, r := newTestRegistry(t)
+	defer server.Close()
+	defer Registries.Remove(r.ID)
+
+	img, err := GetImage(context.Background(), r.Alias, "test", "multi")
+	Convey("When the registry serves a manifest list we should get back an image for each platform", t, func() {
+		So(err, ShouldBeNil)
+		So(img.MediaType, ShouldEqual, MediaTypeManifestList)
+		So(len(img.Platforms), ShouldEqual, 1)
+		So(img.Platforms[0].Digest, ShouldEqual, "sha256:arm64")
+		So(img.Platforms[0].PlatformName(), ShouldEqual, "linux/arm64/v8")
+	})
+
+	Convey("Selecting a platform should return its image, and the default otherwise", t, func() {
+		So(img.SelectPlatform("linux/arm64/v8").Digest, ShouldEqual, "sha256:arm64")
+		So(img.SelectPlatform("windows/amd64").Digest, ShouldEqual, img.Digest)
+		So(len(img.FsLayers), ShouldEqual, 2)
+	})
+}
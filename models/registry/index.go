@@ -0,0 +1,613 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pivotal-golang/bytefmt"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+	bolt "go.etcd.io/bbolt"
+)
+
+// IndexInterval is how often the metadata index crawls every registry
+var IndexInterval = 15 * time.Minute
+
+// MetadataIndex is the index pages read from instead of querying the registries, it is nil when indexing is disabled
+var MetadataIndex *Index
+
+// ErrRefreshInProgress is returned when a registry is refreshed while it is already being crawled
+var ErrRefreshInProgress = errors.New("The registry is already being refreshed")
+
+// Buckets of the index, repositories and tags are keyed by registry ID, repository and tag separated by keySeparator
+var (
+	registriesBucket   = []byte("registries")
+	repositoriesBucket = []byte("repositories")
+	tagsBucket         = []byte("tags")
+)
+
+const keySeparator = "\x00"
+
+// IndexedRegistry contains the totals of a registry from its last crawl, with the number of manifests
+// the crawl fetched and the number it revalidated from the manifest cache
+type IndexedRegistry struct {
+	Registry    string    `json:"registry"`
+	RepoCount   int       `json:"repo_count"`
+	TagCount    int       `json:"tag_count"`
+	Size        int64     `json:"size"`
+	Fetched     int64     `json:"manifests_fetched"`
+	Revalidated int64     `json:"manifests_revalidated"`
+	Refreshed   time.Time `json:"refreshed"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// IndexedRepository contains a repository from the last crawl of its registry
+type IndexedRepository struct {
+	Registry  string    `json:"registry"`
+	Name      string    `json:"name"`
+	TagCount  int       `json:"tag_count"`
+	Size      int64     `json:"size"`
+	Refreshed time.Time `json:"refreshed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// IndexedTag contains the image information of a tag from the last crawl of its repository
+type IndexedTag struct {
+	TagForView
+	Repository string    `json:"repository"`
+	Refreshed  time.Time `json:"refreshed"`
+}
+
+// Index is a local BoltDB store of the repositories, tags, digests and sizes of every registry.
+// It is filled by crawling the registries in the background so page loads don't have to.
+type Index struct {
+	db *bolt.DB
+
+	sync.Mutex
+	refreshing map[string]bool
+}
+
+// OpenIndex opens or creates the index at path. Registries that are removed are dropped from it.
+func OpenIndex(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{registriesBucket, repositoriesBucket, tagsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ix := &Index{db: db, refreshing: make(map[string]bool)}
+	Registries.Subscribe(func(e RegistryEvent) {
+		// A registry with a new URI or credentials may list other repositories, so it is crawled again from scratch
+		changed := e.Type == RegistryUpdated && e.Previous.clientKey() != e.Registry.clientKey()
+		if e.Type == RegistryRemoved || changed {
+			if err := ix.Remove(e.Previous.ID); err != nil {
+				utils.Log.WithFields(logrus.Fields{
+					"Registry": e.Previous.Alias,
+					"Error":    err,
+				}).Error("Unable to remove the registry from the metadata index!")
+			}
+		}
+	})
+	return ix, nil
+}
+
+// Close closes the index
+func (ix *Index) Close() error {
+	return ix.db.Close()
+}
+
+// Run crawls every registry straight away and then every interval until the context is done
+func (ix *Index) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, r := range Registries.List() {
+			if ctx.Err() != nil {
+				return
+			}
+			ix.Refresh(ctx, r)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh crawls the registry into the index. Repositories that fail to crawl keep their previous
+// entries with the error, and repositories that are no longer listed are dropped.
+func (ix *Index) Refresh(ctx context.Context, r Registry) error {
+	if !ix.start(r.ID) {
+		return ErrRefreshInProgress
+	}
+	defer ix.finish(r.ID)
+	return ix.refresh(ctx, r)
+}
+
+// RefreshInBackground starts crawling the registry into the index without waiting for the crawl to finish,
+// it fails with ErrRefreshInProgress if the registry is already being crawled
+func (ix *Index) RefreshInBackground(ctx context.Context, r Registry) error {
+	if !ix.start(r.ID) {
+		return ErrRefreshInProgress
+	}
+	go func() {
+		defer ix.finish(r.ID)
+		ix.refresh(ctx, r)
+	}()
+	return nil
+}
+
+// refresh crawls the registry into the index, the caller must have started the refresh
+func (ix *Index) refresh(ctx context.Context, r Registry) error {
+	start := time.Now()
+	summary := IndexedRegistry{Registry: r.ID, Refreshed: start}
+	stats := &FetchStats{}
+	ctx = WithFetchStats(ctx, stats)
+	repos, err := GetRepositoriesFromRegistry(ctx, r.Alias)
+	if err != nil {
+		// Keep the totals of the last successful crawl
+		if previous, ok := ix.Registry(r.ID); ok {
+			summary = previous
+		}
+		summary.Error = err.Error()
+		ix.put(registriesBucket, registryKey(r.ID), summary)
+		return err
+	}
+
+	for _, name := range repos.Repositories {
+		repo, err := ix.refreshRepository(ctx, r, name)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && summary.Error == "" {
+			summary.Error = err.Error()
+		}
+		summary.RepoCount++
+		summary.TagCount += repo.TagCount
+		summary.Size += repo.Size
+	}
+	if err := ix.prune(r.ID, repos.Repositories); err != nil {
+		return err
+	}
+	summary.Fetched, summary.Revalidated = stats.Fetched, stats.Revalidated
+	if err := ix.put(registriesBucket, registryKey(r.ID), summary); err != nil {
+		return err
+	}
+
+	utils.Log.WithFields(logrus.Fields{
+		"Registry":     r.Alias,
+		"Repositories": summary.RepoCount,
+		"Tags":         summary.TagCount,
+		"Fetched":      summary.Fetched,
+		"Revalidated":  summary.Revalidated,
+		"Duration":     time.Since(start).String(),
+	}).Info("Refreshed the metadata index of the registry")
+	return nil
+}
+
+// RefreshRepository crawls a single repository of the registry into the index, e.g after a tag was deleted
+func (ix *Index) RefreshRepository(ctx context.Context, r Registry, repository string) error {
+	if _, err := ix.refreshRepository(ctx, r, repository); err != nil {
+		return err
+	}
+	return ix.updateTotals(r.ID)
+}
+
+// updateTotals recounts the totals of a crawled registry from its indexed repositories
+func (ix *Index) updateTotals(registryID string) error {
+	summary, ok := ix.Registry(registryID)
+	if !ok {
+		return nil
+	}
+	repos, _ := ix.Repositories(registryID)
+	summary.RepoCount, summary.TagCount, summary.Size = len(repos), 0, 0
+	for _, repo := range repos {
+		summary.TagCount += repo.TagCount
+		summary.Size += repo.Size
+	}
+	return ix.put(registriesBucket, registryKey(registryID), summary)
+}
+
+// refreshRepository crawls the tags of a repository and replaces its entries in the index.
+// Only the tag names of public registries are crawled since each manifest pulled counts against their rate limit.
+func (ix *Index) refreshRepository(ctx context.Context, r Registry, name string) (IndexedRepository, error) {
+	repo := IndexedRepository{Registry: r.ID, Name: name, Refreshed: time.Now()}
+
+	var tags TagsForView
+	var err error
+	if r.IsPublic() {
+		var names Tags
+		names, err = GetTags(ctx, r.Alias, name)
+		for _, tag := range names.Tags {
+			tags = append(tags, TagForView{Name: tag})
+		}
+	} else {
+		tags, err = GetTagsForView(ctx, r.Alias, name)
+	}
+
+	if err != nil {
+		utils.Log.WithFields(logrus.Fields{
+			"Registry":   r.Alias,
+			"Repository": name,
+			"Error":      err,
+		}).Warn("Unable to refresh a repository in the metadata index, keeping its previous entries")
+
+		if previous, ok := ix.repository(r.ID, name); ok {
+			repo = previous
+		}
+		repo.Error = err.Error()
+		if putErr := ix.put(repositoriesBucket, repositoryKey(r.ID, name), repo); putErr != nil {
+			return repo, putErr
+		}
+		return repo, err
+	}
+
+	for _, t := range tags {
+		repo.TagCount++
+		repo.Size += t.SizeInt
+	}
+
+	err = ix.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tagsBucket)
+		if err := deletePrefix(b, repositoryKey(r.ID, name)+keySeparator); err != nil {
+			return err
+		}
+		for _, t := range tags {
+			body, err := json.Marshal(IndexedTag{TagForView: t, Repository: name, Refreshed: repo.Refreshed})
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(tagKey(r.ID, name, t.Name)), body); err != nil {
+				return err
+			}
+		}
+		body, err := json.Marshal(repo)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(repositoriesBucket).Put([]byte(repositoryKey(r.ID, name)), body)
+	})
+	return repo, err
+}
+
+// PutTag adds or replaces a single tag of a repository in the index, e.g when a registry notifies us of a push
+func (ix *Index) PutTag(registryID string, repository string, tag TagForView) error {
+	err := ix.db.Update(func(tx *bolt.Tx) error {
+		body, err := json.Marshal(IndexedTag{TagForView: tag, Repository: repository, Refreshed: time.Now()})
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(tagsBucket).Put([]byte(tagKey(registryID, repository, tag.Name)), body); err != nil {
+			return err
+		}
+		return recountRepository(tx, registryID, repository)
+	})
+	if err != nil {
+		return err
+	}
+	return ix.updateTotals(registryID)
+}
+
+// DeleteTags removes the tags of a repository named tag, or every tag pointing at digest when tag is empty,
+// and returns the number of tags removed
+func (ix *Index) DeleteTags(registryID string, repository string, tag string, digest string) (int, error) {
+	removed := 0
+	err := ix.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(tagsBucket)
+		prefix := []byte(repositoryKey(registryID, repository) + keySeparator)
+		keys := [][]byte{}
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			t := IndexedTag{}
+			if json.Unmarshal(v, &t) != nil {
+				continue
+			}
+			if (tag != "" && t.Name == tag) || (tag == "" && digest != "" && t.Digest == digest) {
+				keys = append(keys, append([]byte{}, k...))
+			}
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		if removed == 0 {
+			return nil
+		}
+		return recountRepository(tx, registryID, repository)
+	})
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+	return removed, ix.updateTotals(registryID)
+}
+
+// recountRepository rebuilds the tag count and size of an indexed repository from its indexed tags
+func recountRepository(tx *bolt.Tx, registryID string, name string) error {
+	key := []byte(repositoryKey(registryID, name))
+	repo := IndexedRepository{Registry: registryID, Name: name}
+	if body := tx.Bucket(repositoriesBucket).Get(key); body != nil {
+		json.Unmarshal(body, &repo)
+	}
+	repo.TagCount, repo.Size, repo.Refreshed = 0, 0, time.Now()
+
+	prefix := []byte(repositoryKey(registryID, name) + keySeparator)
+	c := tx.Bucket(tagsBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		t := IndexedTag{}
+		if json.Unmarshal(v, &t) == nil {
+			repo.TagCount++
+			repo.Size += t.SizeInt
+		}
+	}
+
+	body, err := json.Marshal(repo)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(repositoriesBucket).Put(key, body)
+}
+
+// Registry returns the totals of the registry from its last crawl, if it has been crawled
+func (ix *Index) Registry(registryID string) (IndexedRegistry, bool) {
+	summary := IndexedRegistry{}
+	ok := ix.get(registriesBucket, registryKey(registryID), &summary)
+	return summary, ok
+}
+
+// Repositories returns the indexed repositories of the registry sorted by name,
+// and false if the registry hasn't been crawled yet
+func (ix *Index) Repositories(registryID string) ([]IndexedRepository, bool) {
+	if _, ok := ix.Registry(registryID); !ok {
+		return nil, false
+	}
+
+	repos := []IndexedRepository{}
+	ix.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(registryKey(registryID) + keySeparator)
+		c := tx.Bucket(repositoriesBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			repo := IndexedRepository{}
+			if json.Unmarshal(v, &repo) == nil {
+				repos = append(repos, repo)
+			}
+		}
+		return nil
+	})
+	return repos, true
+}
+
+// Tags returns the indexed tags of a repository sorted by name, and false if the repository hasn't been crawled yet
+func (ix *Index) Tags(registryID string, repository string) ([]IndexedTag, bool) {
+	if _, ok := ix.repository(registryID, repository); !ok {
+		return nil, false
+	}
+
+	tags := []IndexedTag{}
+	ix.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(repositoryKey(registryID, repository) + keySeparator)
+		c := tx.Bucket(tagsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			t := IndexedTag{}
+			if json.Unmarshal(v, &t) == nil {
+				tags = append(tags, t)
+			}
+		}
+		return nil
+	})
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags, true
+}
+
+// Remove drops every entry of the registry from the index
+func (ix *Index) Remove(registryID string) error {
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		prefix := registryKey(registryID) + keySeparator
+		if err := deletePrefix(tx.Bucket(tagsBucket), prefix); err != nil {
+			return err
+		}
+		if err := deletePrefix(tx.Bucket(repositoriesBucket), prefix); err != nil {
+			return err
+		}
+		return tx.Bucket(registriesBucket).Delete([]byte(registryKey(registryID)))
+	})
+}
+
+// prune drops the repositories of the registry that are not in the passed list
+func (ix *Index) prune(registryID string, repositories []string) error {
+	listed := make(map[string]bool, len(repositories))
+	for _, name := range repositories {
+		listed[repositoryKey(registryID, name)] = true
+	}
+
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		prefix := []byte(registryKey(registryID) + keySeparator)
+		stale := []string{}
+		c := tx.Bucket(repositoriesBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if !listed[string(k)] {
+				stale = append(stale, string(k))
+			}
+		}
+		for _, key := range stale {
+			if err := deletePrefix(tx.Bucket(tagsBucket), key+keySeparator); err != nil {
+				return err
+			}
+			if err := tx.Bucket(repositoriesBucket).Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// repository returns the indexed repository
+func (ix *Index) repository(registryID string, name string) (IndexedRepository, bool) {
+	repo := IndexedRepository{}
+	ok := ix.get(repositoriesBucket, repositoryKey(registryID, name), &repo)
+	return repo, ok
+}
+
+// get unmarshals the value stored at key in the bucket into v, reporting whether it was found
+func (ix *Index) get(bucket []byte, key string, v interface{}) bool {
+	found := false
+	ix.db.View(func(tx *bolt.Tx) error {
+		if body := tx.Bucket(bucket).Get([]byte(key)); body != nil {
+			found = json.Unmarshal(body, v) == nil
+		}
+		return nil
+	})
+	return found
+}
+
+// put stores v as JSON at key in the bucket
+func (ix *Index) put(bucket []byte, key string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ix.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), body)
+	})
+}
+
+// start marks the registry as being crawled, returning false if it already is
+func (ix *Index) start(registryID string) bool {
+	ix.Lock()
+	defer ix.Unlock()
+	if ix.refreshing[registryID] {
+		return false
+	}
+	ix.refreshing[registryID] = true
+	return true
+}
+
+// finish marks the registry as no longer being crawled
+func (ix *Index) finish(registryID string) {
+	ix.Lock()
+	defer ix.Unlock()
+	delete(ix.refreshing, registryID)
+}
+
+// deletePrefix deletes every key in the bucket starting with prefix
+func deletePrefix(b *bolt.Bucket, prefix string) error {
+	keys := [][]byte{}
+	c := b.Cursor()
+	for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registryKey(registryID string) string {
+	return registryID
+}
+
+func repositoryKey(registryID string, repository string) string {
+	return registryID + keySeparator + repository
+}
+
+func tagKey(registryID string, repository string, tag string) string {
+	return repositoryKey(registryID, repository) + keySeparator + tag
+}
+
+// SizeStr returns the size of the registry in a human readable form
+func (s IndexedRegistry) SizeStr() string {
+	return bytefmt.ByteSize(uint64(s.Size))
+}
+
+// RefreshedAgo returns how long ago the registry was crawled, e.g 5 minutes ago
+func (s IndexedRegistry) RefreshedAgo() string {
+	return utils.TimeAgo(s.Refreshed)
+}
+
+// ListRepositories returns the repositories of the registry with their tag counts. They are read from the
+// metadata index once the registry has been crawled, and requested from the registry until then.
+func ListRepositories(ctx context.Context, registryName string) []Repository {
+	if r, ok := Registries.GetByAlias(registryName); ok && MetadataIndex != nil {
+		if indexed, ok := MetadataIndex.Repositories(r.ID); ok {
+			repos := []Repository{}
+			for _, repo := range indexed {
+				repos = append(repos, Repository{
+					Name:         repo.Name,
+					Registry:     registryName,
+					EncodedURI:   url.QueryEscape(repo.Name),
+					TagCount:     repo.TagCount,
+					Refreshed:    repo.Refreshed,
+					RefreshedAgo: utils.TimeAgo(repo.Refreshed),
+				})
+			}
+			return repos
+		}
+	}
+
+	repos := GetRepositories(ctx, registryName)
+	for i, repo := range repos {
+		tags, _ := GetTags(ctx, registryName, repo.Name)
+		repos[i].TagCount = len(tags.Tags)
+	}
+	return repos
+}
+
+// CountRepositories returns the number of repositories in the registry, from the metadata index once it has been crawled
+func CountRepositories(ctx context.Context, registryName string) int {
+	if r, ok := Registries.GetByAlias(registryName); ok && MetadataIndex != nil {
+		if summary, ok := MetadataIndex.Registry(r.ID); ok {
+			return summary.RepoCount
+		}
+	}
+	return len(GetRepositories(ctx, registryName))
+}
+
+// GetIndexedTagsForView returns every tag of a repository of a private registry from the metadata index,
+// with the time it was crawled. It returns false when the tags have to be requested from the registry.
+func GetIndexedTagsForView(registryName string, repositoryName string) (TagsForView, time.Time, bool) {
+	r, ok := Registries.GetByAlias(registryName)
+	if !ok || MetadataIndex == nil || r.IsPublic() {
+		return nil, time.Time{}, false
+	}
+	indexed, ok := MetadataIndex.Tags(r.ID, repositoryName)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	tags := TagsForView{}
+	var refreshed time.Time
+	for _, t := range indexed {
+		t.TimeAgo = utils.TimeAgo(t.UpdatedTime)
+		tags = append(tags, t.TagForView)
+		refreshed = t.Refreshed
+	}
+	if repo, ok := MetadataIndex.repository(r.ID, repositoryName); ok {
+		refreshed = repo.Refreshed
+	}
+	return tags, refreshed, true
+}
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestIndexRefresh checks that a crawl indexes the repositories and tags of a registry and drops the ones that are gone
+func TestIndexRefresh(t *testing.T) {
+
+	catalog := `{"repositories": ["test", "other"]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/_catalog":
+			w.Write([]byte(catalog))
+		case "/v2/test/tags/list":
+			w.Write([]byte(`{"name": "test", "tags": ["latest"]}`))
+		case "/v2/other/tags/list":
+			w.Write([]byte(`{"name": "other", "tags": []}`))
+		case "/v2/test/manifests/latest":
+			w.Header().Set("Content-Type", MediaTypeManifestV2)
+			w.Header().Set("Docker-Content-Digest", "sha256:manifest")
+			w.Write([]byte(testManifestV2))
+		case "/v2/test/blobs/sha256:config":
+			w.Write([]byte(testImageConfig))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ix, err := OpenIndex(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+
+	Convey("A registry that hasn't been crawled should not be in the index", t, func() {
+		_, ok := ix.Repositories(r.ID)
+		So(ok, ShouldBeFalse)
+	})
+
+	err = ix.Refresh(context.Background(), r)
+	Convey("After a crawl the repositories and tags should be read from the index", t, func() {
+		So(err, ShouldBeNil)
+
+		repos, ok := ix.Repositories(r.ID)
+		So(ok, ShouldBeTrue)
+		So(len(repos), ShouldEqual, 2)
+
+		tags, ok := ix.Tags(r.ID, "test")
+		So(ok, ShouldBeTrue)
+		So(len(tags), ShouldEqual, 1)
+		So(tags[0].Name, ShouldEqual, "latest")
+		So(tags[0].Refreshed.IsZero(), ShouldBeFalse)
+
+		summary, ok := ix.Registry(r.ID)
+		So(ok, ShouldBeTrue)
+		So(summary.RepoCount, ShouldEqual, 2)
+		So(summary.TagCount, ShouldEqual, 1)
+		So(summary.Size, ShouldEqual, tags[0].SizeInt)
+	})
+
+	catalog = `{"repositories": ["test"]}`
+	err = ix.Refresh(context.Background(), r)
+	Convey("Repositories that are no longer in the catalog should be dropped", t, func() {
+		So(err, ShouldBeNil)
+		repos, _ := ix.Repositories(r.ID)
+		So(len(repos), ShouldEqual, 1)
+		So(repos[0].Name, ShouldEqual, "test")
+		_, ok := ix.Tags(r.ID, "other")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Each refresh should count the manifests it fetched and the unchanged ones it revalidated", t, func() {
+		summary, _ := ix.Registry(r.ID)
+		So(summary.Fetched, ShouldEqual, 0)
+		So(summary.Revalidated, ShouldEqual, 1)
+	})
+
+	catalog = `not json`
+	err = ix.Refresh(context.Background(), r)
+	Convey("A failed crawl should keep the previous entries and record the error", t, func() {
+		So(err, ShouldNotBeNil)
+		summary, _ := ix.Registry(r.ID)
+		So(summary.RepoCount, ShouldEqual, 1)
+		So(summary.Error, ShouldNotBeEmpty)
+	})
+
+	Convey("A registry that is already being crawled should not be refreshed again", t, func() {
+		So(ix.start(r.ID), ShouldBeTrue)
+		So(ix.RefreshInBackground(context.Background(), r), ShouldEqual, ErrRefreshInProgress)
+		So(ix.Refresh(context.Background(), r), ShouldEqual, ErrRefreshInProgress)
+		ix.finish(r.ID)
+	})
+
+	Convey("Changing the URI of the registry should drop its entries", t, func() {
+		catalog = `{"repositories": ["test"]}`
+		So(ix.Refresh(context.Background(), r), ShouldBeNil)
+		moved, err := ParseRegistry(server.URL + "/moved/v2")
+		So(err, ShouldBeNil)
+		So(Registries.Replace(r.ID, moved), ShouldBeNil)
+		_, ok := ix.Registry(r.ID)
+		So(ok, ShouldBeFalse)
+		_, ok = ix.Tags(r.ID, "test")
+		So(ok, ShouldBeFalse)
+
+		So(Registries.Replace(r.ID, r), ShouldBeNil)
+		So(ix.Refresh(context.Background(), r), ShouldBeNil)
+	})
+
+	Registries.Remove(r.ID)
+	Convey("Removing the registry should drop it from the index", t, func() {
+		_, ok := ix.Registry(r.ID)
+		So(ok, ShouldBeFalse)
+		_, ok = ix.Tags(r.ID, "test")
+		So(ok, ShouldBeFalse)
+	})
+}
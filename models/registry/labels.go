@@ -0,0 +1,157 @@
+package registry
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validLabelKey matches the label keys a registry can carry, e.g env, region or team.example.com/owner
+var validLabelKey = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// validLabelValue matches the label values a registry can carry, e.g prod or eu-west-1
+var validLabelValue = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+
+// Label selector operators
+const (
+	selectorEquals    = "="
+	selectorNotEquals = "!="
+	selectorExists    = "exists"
+	selectorNotExists = "!exists"
+)
+
+// labelRequirement is a single comma separated term of a label selector
+type labelRequirement struct {
+	Key      string
+	Operator string
+	Value    string
+}
+
+// LabelSelector selects registries by their labels. Every requirement must match.
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses a comma separated label selector, e.g env=prod,region!=eu,team,!deprecated
+// selects the registries labelled env=prod that are not in region eu, have a team label and no deprecated label.
+// An empty selector selects every registry.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	s := LabelSelector{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		req := labelRequirement{}
+		switch {
+		case strings.Contains(term, "!="):
+			i := strings.Index(term, "!=")
+			req = labelRequirement{Key: term[:i], Operator: selectorNotEquals, Value: term[i+2:]}
+		case strings.Contains(term, "=="):
+			i := strings.Index(term, "==")
+			req = labelRequirement{Key: term[:i], Operator: selectorEquals, Value: term[i+2:]}
+		case strings.Contains(term, "="):
+			i := strings.Index(term, "=")
+			req = labelRequirement{Key: term[:i], Operator: selectorEquals, Value: term[i+1:]}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{Key: term[1:], Operator: selectorNotExists}
+		default:
+			req = labelRequirement{Key: term, Operator: selectorExists}
+		}
+		req.Key = strings.TrimSpace(req.Key)
+		req.Value = strings.TrimSpace(req.Value)
+
+		if !validLabelKey.MatchString(req.Key) || !validLabelValue.MatchString(req.Value) {
+			return nil, errors.New("Invalid label selector " + term + ", expected e.g env=prod,region!=eu,team")
+		}
+		s = append(s, req)
+	}
+	return s, nil
+}
+
+// Matches reports whether the labels satisfy every requirement of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.Key]
+		switch req.Operator {
+		case selectorEquals:
+			if !ok || value != req.Value {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && value == req.Value {
+				return false
+			}
+		case selectorExists:
+			if !ok {
+				return false
+			}
+		case selectorNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the selector in the form it is parsed from
+func (s LabelSelector) String() string {
+	terms := []string{}
+	for _, req := range s {
+		switch req.Operator {
+		case selectorExists:
+			terms = append(terms, req.Key)
+		case selectorNotExists:
+			terms = append(terms, "!"+req.Key)
+		default:
+			terms = append(terms, req.Key+req.Operator+req.Value)
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+// ParseLabels parses comma separated key=value labels, e.g env=prod, region=eu
+func ParseLabels(labels string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, label := range strings.Split(labels, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		i := strings.Index(label, "=")
+		if i < 0 {
+			return nil, errors.New("Invalid label " + label + ", expected key=value")
+		}
+		key, value := strings.TrimSpace(label[:i]), strings.TrimSpace(label[i+1:])
+		if !validLabelKey.MatchString(key) || !validLabelValue.MatchString(value) {
+			return nil, errors.New("Invalid label " + label + ", keys and values may only contain letters, digits, '.', '_' and '-'")
+		}
+		m[key] = value
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// LabelList returns the labels of the registry as sorted key=value pairs
+func (r Registry) LabelList() []string {
+	labels := []string{}
+	for k, v := range r.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Select returns the active registries matching the label selector, sorted by alias
+func (s *RegistryStore) Select(selector LabelSelector) []Registry {
+	registries := []Registry{}
+	for _, r := range s.List() {
+		if selector.Matches(r.Labels) {
+			registries = append(registries, r)
+		}
+	}
+	return registries
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestLabelSelector checks each selector operator against a set of labels
+func TestLabelSelector(t *testing.T) {
+
+	labels := map[string]string{"env": "prod", "region": "eu", "team": "platform"}
+	tests := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod", true},
+		{"env=dev", false},
+		{"env=prod,region!=eu", false},
+		{"env=prod, region!=us", true},
+		{"team", true},
+		{"owner", false},
+		{"!deprecated", true},
+		{"!team", false},
+	}
+
+	Convey("Each selector should only match the labels that satisfy every term", t, func() {
+		for _, test := range tests {
+			selector, err := ParseLabelSelector(test.selector)
+			So(err, ShouldBeNil)
+			So(selector.Matches(labels), ShouldEqual, test.matches)
+		}
+	})
+
+	Convey("Selectors should be written back in the form they are parsed from", t, func() {
+		selector, _ := ParseLabelSelector(" env==prod ,!deprecated,team")
+		So(selector.String(), ShouldEqual, "env=prod,!deprecated,team")
+	})
+
+	Convey("Malformed selectors should be an error", t, func() {
+		_, err := ParseLabelSelector("env=prod=eu")
+		So(err, ShouldNotBeNil)
+		_, err = ParseLabelSelector("=prod")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// TestSelectRegistries checks that the store selects registries by their labels
+func TestSelectRegistries(t *testing.T) {
+
+	prod, _ := ParseRegistry("https://127.0.0.6:5000/v2")
+	prod.Labels, _ = ParseLabels("env=prod, region=eu")
+	dev, _ := ParseRegistry("https://127.0.0.7:5000/v2")
+	dev.Labels, _ = ParseLabels("env=dev")
+	if err := prod.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(prod.ID)
+	if err := dev.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(dev.ID)
+
+	Convey("Only the registries with matching labels should be selected", t, func() {
+		selector, _ := ParseLabelSelector("env=prod")
+		selected := Registries.Select(selector)
+		So(len(selected), ShouldEqual, 1)
+		So(selected[0].ID, ShouldEqual, prod.ID)
+		So(selected[0].LabelList(), ShouldResemble, []string{"env=prod", "region=eu"})
+	})
+
+	Convey("Labels that aren't key=value pairs should be an error", t, func() {
+		_, err := ParseLabels("env")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NextLink returns the absolute URI of the next page from the RFC 5988 Link header of the response
+// e.g Link: </v2/_catalog?last=b&n=100>; rel="next"
+func NextLink(response *http.Response) string {
+	for _, header := range response.Header["Link"] {
+		for _, link := range strings.Split(header, ",") {
+			target, rel := parseLink(link)
+			if rel != "next" || target == "" {
+				continue
+			}
+
+			// The target is usually relative to the registry so resolve it against the request
+			if response.Request == nil || response.Request.URL == nil {
+				return target
+			}
+			u, err := response.Request.URL.Parse(target)
+			if err != nil {
+				return ""
+			}
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// parseLink splits a single link-value into its target and rel parameter
+func parseLink(link string) (string, string) {
+	parts := strings.Split(link, ";")
+	target := strings.TrimSpace(parts[0])
+	if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+		return "", ""
+	}
+	target = target[1 : len(target)-1]
+
+	var rel string
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], "rel") {
+			rel = strings.Trim(kv[1], `"`)
+		}
+	}
+	return target, rel
+}
@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestNextLink checks that the next page is resolved from the Link header of a response
+func TestNextLink(t *testing.T) {
+
+	requestURL, _ := url.Parse("https://host.domain.com:5000/v2/_catalog?n=100")
+	response := &http.Response{
+		Header:  http.Header{},
+		Request: &http.Request{URL: requestURL},
+	}
+
+	Convey("When there is no Link header there is no next page", t, func() {
+		So(NextLink(response), ShouldEqual, "")
+	})
+
+	response.Header.Set("Link", `</v2/_catalog?last=b&n=100>; rel="next"`)
+	Convey("When the Link header is relative it should be resolved against the request", t, func() {
+		So(NextLink(response), ShouldEqual, "https://host.domain.com:5000/v2/_catalog?last=b&n=100")
+	})
+
+	response.Header.Set("Link", `<https://other.domain.com/v2/_catalog?last=c&n=100>; rel="prev"`)
+	Convey("When the Link header is not for the next page it should be ignored", t, func() {
+		So(NextLink(response), ShouldEqual, "")
+	})
+}
@@ -0,0 +1,252 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"time"
+
+	"github.com/pivotal-golang/bytefmt"
+)
+
+// Manifest media types served by docker distribution and OCI registries
+// https://docs.docker.com/registry/spec/manifest-v2-2/
+// https://github.com/opencontainers/image-spec/blob/master/manifest.md
+const (
+	MediaTypeManifestV1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSignedManifestV1 = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeManifestV2       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCIManifest      = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeManifestList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIIndex         = "application/vnd.oci.image.index.v1+json"
+)
+
+// ManifestAcceptTypes are sent in the Accept header when requesting a manifest, preferred formats first
+var ManifestAcceptTypes = []string{
+	MediaTypeManifestList,
+	MediaTypeOCIIndex,
+	MediaTypeManifestV2,
+	MediaTypeOCIManifest,
+	MediaTypeSignedManifestV1,
+	MediaTypeManifestV1,
+}
+
+// Descriptor references a blob or manifest by its digest and size
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Platform describes the os and architecture a manifest in a manifest list was built for
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant"`
+}
+
+// ManifestList contains a manifest list or OCI image index
+// https://docs.docker.com/registry/spec/manifest-v2-2/#manifest-list
+type ManifestList struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// ManifestV2 contains a schema2 or OCI image manifest
+type ManifestV2 struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// ImageConfig contains the image configuration blob referenced by a schema2 or OCI manifest
+type ImageConfig struct {
+	Architecture  string          `json:"architecture"`
+	Variant       string          `json:"variant"`
+	OS            string          `json:"os"`
+	Created       time.Time       `json:"created"`
+	DockerVersion string          `json:"docker_version"`
+	History       []ConfigHistory `json:"history"`
+}
+
+// ConfigHistory contains a single build step from the image configuration
+type ConfigHistory struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Comment    string    `json:"comment"`
+	EmptyLayer bool      `json:"empty_layer"`
+}
+
+// manifestMediaType works out the manifest format from the Content-Type header, falling back to the manifest body
+func manifestMediaType(contentType string, body []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "", "application/json", "text/plain", "application/octet-stream":
+		default:
+			return mediaType
+		}
+	}
+
+	m := struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		MediaType     string `json:"mediaType"`
+	}{}
+	json.Unmarshal(body, &m)
+	switch {
+	case m.MediaType != "":
+		return m.MediaType
+	case m.SchemaVersion == 2 && bytes.Contains(body, []byte(`"manifests"`)):
+		return MediaTypeOCIIndex
+	case m.SchemaVersion == 2:
+		return MediaTypeOCIManifest
+	}
+	return MediaTypeManifestV1
+}
+
+// parseManifestV2 fills the image from a schema2 or OCI manifest and its config blob.
+// The layers and history are ordered from the top layer down to match schema1.
+func parseManifestV2(ctx context.Context, r Registry, c *RegistryClient, repositoryName string, body []byte, img *Image) error {
+	m := ManifestV2{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return err
+	}
+	img.SchemaVersion = m.SchemaVersion
+
+	// Layer sizes come straight from the descriptors, and are cached for schema1 manifests sharing the layers
+	for i := len(m.Layers) - 1; i >= 0; i-- {
+		BlobSizes.Put(r.ID, m.Layers[i].Digest, m.Layers[i].Size)
+		img.FsLayers = append(img.FsLayers, FsLayer{
+			BlobSum: m.Layers[i].Digest,
+			Size:    m.Layers[i].Size,
+			SizeStr: bytefmt.ByteSize(uint64(m.Layers[i].Size)),
+		})
+	}
+	img.ContainsV1Size = true
+
+	// The config blob holds the platform and history of the image
+	configBody, err := getBlob(ctx, c, repositoryName, m.Config.Digest)
+	if err != nil {
+		return err
+	}
+	config := ImageConfig{}
+	if err := json.Unmarshal(configBody, &config); err != nil {
+		return err
+	}
+	img.Architecture = config.Architecture
+	img.OS = config.OS
+	img.Variant = config.Variant
+
+	// Some builders don't write any history, so use one entry per layer
+	if len(config.History) == 0 {
+		for range m.Layers {
+			config.History = append(config.History, ConfigHistory{Created: config.Created})
+		}
+	}
+
+	// Match each history entry that created a layer with its descriptor
+	layerIndex := 0
+	history := make([]History, 0, len(config.History))
+	for _, h := range config.History {
+		v1 := V1Compatibility{
+			Created:       h.Created,
+			DockerVersion: config.DockerVersion,
+			Architecture:  config.Architecture,
+			Os:            config.OS,
+		}
+		v1.ContainerConfig.Cmd = []string{h.CreatedBy}
+		v1.ContainerConfig.CmdClean = cleanCmd(h.CreatedBy)
+
+		if !h.EmptyLayer && layerIndex < len(m.Layers) {
+			v1.ID = m.Layers[layerIndex].Digest
+			v1.IDShort = shortID(v1.ID)
+			v1.Size = int(m.Layers[layerIndex].Size)
+			layerIndex++
+		}
+		v1.SizeStr = bytefmt.ByteSize(uint64(v1.Size))
+
+		history = append([]History{{V1Compatibility: v1}}, history...)
+	}
+	img.History = history
+
+	return nil
+}
+
+// parseManifestList fills the image from a manifest list or OCI index by requesting the manifest for each platform.
+// The default platform, linux/amd64 when present, is used for the rest of the image.
+func parseManifestList(ctx context.Context, r Registry, c *RegistryClient, repositoryName string, body []byte, img *Image) error {
+	list := ManifestList{}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return err
+	}
+
+	platforms := []Image{}
+	for _, d := range list.Manifests {
+
+		// Skip entries that aren't images such as build attestations
+		if d.Platform != nil && d.Platform.OS == "unknown" {
+			continue
+		}
+
+		p, err := getImage(ctx, r, c, repositoryName, d.Digest)
+		if err != nil {
+			return err
+		}
+		if p.Digest == "" {
+			p.Digest = d.Digest
+		}
+
+		// The list platform is authoritative, e.g the config blob often leaves out the variant
+		if d.Platform != nil {
+			p.OS = d.Platform.OS
+			p.Architecture = d.Platform.Architecture
+			p.Variant = d.Platform.Variant
+		}
+		platforms = append(platforms, p)
+	}
+	if len(platforms) == 0 {
+		return errors.New("The manifest list did not contain any images")
+	}
+
+	def := platforms[0]
+	for _, p := range platforms {
+		if p.OS == "linux" && p.Architecture == "amd64" {
+			def = p
+			break
+		}
+	}
+
+	name, digest, mediaType := img.Name, img.Digest, img.MediaType
+	*img = def
+	img.Name = name
+	img.Digest = digest
+	img.MediaType = mediaType
+	img.Platforms = platforms
+
+	return nil
+}
+
+// getBlob downloads the blob with the passed digest from the repository
+// GET /v2/<name>/blobs/<digest>
+func getBlob(ctx context.Context, c *RegistryClient, repositoryName string, digest string) ([]byte, error) {
+	if digest == "" {
+		return nil, errors.New("The manifest did not reference a config blob")
+	}
+
+	response, err := c.Get(ctx, c.uri+"/"+repositoryName+"/blobs/"+digest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return nil, errors.New("Blob request for " + digest + " returned " + response.Status)
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
@@ -0,0 +1,243 @@
+package registry
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ManifestCacheSize is the number of tags and parsed manifests kept by the manifest cache,
+// the least recently used are dropped first
+var ManifestCacheSize = 10000
+
+// Manifests caches the digest and ETag of each tag with the image parsed from its manifest, so a tag is
+// only fetched and parsed again once its digest has changed. Images are kept by digest since manifests
+// are content addressed, which also covers tags that move to a digest already seen.
+var Manifests = NewManifestCache()
+
+// ManifestCache is a bounded least recently used cache of tag digests and parsed images keyed by registry ID
+// and repository. It is safe for concurrent use.
+type ManifestCache struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// manifestEntry is either a cached tag or a cached image
+type manifestEntry struct {
+	key   string
+	tag   *cachedTag
+	image *Image
+}
+
+// cachedTag is the digest and ETag a tag had when it was last fetched or revalidated
+type cachedTag struct {
+	Digest string
+	ETag   string
+}
+
+// FetchStats counts the manifests fetched from the registry and the ones revalidated from the manifest
+// cache while it is attached to a context, e.g during a refresh of the metadata index
+type FetchStats struct {
+	Fetched     int64
+	Revalidated int64
+}
+
+type fetchStatsKey struct{}
+
+// WithFetchStats returns a context counting the manifests fetched with it into stats
+func WithFetchStats(ctx context.Context, stats *FetchStats) context.Context {
+	return context.WithValue(ctx, fetchStatsKey{}, stats)
+}
+
+// countFetch adds a fetched or revalidated manifest to the stats of the context, if it has any
+func countFetch(ctx context.Context, fetched bool) {
+	stats, ok := ctx.Value(fetchStatsKey{}).(*FetchStats)
+	if !ok {
+		return
+	}
+	if fetched {
+		atomic.AddInt64(&stats.Fetched, 1)
+	} else {
+		atomic.AddInt64(&stats.Revalidated, 1)
+	}
+}
+
+// NewManifestCache creates a ManifestCache which forgets the manifests of registries once they are removed
+func NewManifestCache() *ManifestCache {
+	mc := &ManifestCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	Registries.Subscribe(func(e RegistryEvent) {
+		if e.Type == RegistryRemoved {
+			mc.forget(e.Previous.ID + keySeparator)
+		}
+	})
+	return mc
+}
+
+// Len returns the number of cached tags and images
+func (mc *ManifestCache) Len() int {
+	mc.Lock()
+	defer mc.Unlock()
+	return mc.order.Len()
+}
+
+// Forget drops the cached digest of a tag, e.g after it was deleted
+func (mc *ManifestCache) Forget(registryID string, repositoryName string, tagName string) {
+	mc.Lock()
+	defer mc.Unlock()
+	if el, ok := mc.entries[manifestTagKey(registryID, repositoryName, tagName)]; ok {
+		mc.remove(el)
+	}
+}
+
+// ForgetDigest drops the cached image with the digest and the cached tags pointing at it, e.g after it was deleted
+func (mc *ManifestCache) ForgetDigest(registryID string, repositoryName string, digest string) {
+	mc.Lock()
+	defer mc.Unlock()
+	if el, ok := mc.entries[manifestImageKey(registryID, repositoryName, digest)]; ok {
+		mc.remove(el)
+	}
+	prefix := manifestTagKey(registryID, repositoryName, "")
+	for el := mc.order.Front(); el != nil; {
+		next := el.Next()
+		entry := el.Value.(*manifestEntry)
+		if entry.tag != nil && entry.tag.Digest == digest && strings.HasPrefix(entry.key, prefix) {
+			mc.remove(el)
+		}
+		el = next
+	}
+}
+
+// tag returns the cached digest and ETag of the tag
+func (mc *ManifestCache) tag(registryID string, repositoryName string, tagName string) (cachedTag, bool) {
+	mc.Lock()
+	defer mc.Unlock()
+	el, ok := mc.entries[manifestTagKey(registryID, repositoryName, tagName)]
+	if !ok {
+		return cachedTag{}, false
+	}
+	mc.order.MoveToFront(el)
+	return *el.Value.(*manifestEntry).tag, true
+}
+
+// image returns the cached image parsed from the manifest with the digest
+func (mc *ManifestCache) image(registryID string, repositoryName string, digest string) (Image, bool) {
+	mc.Lock()
+	defer mc.Unlock()
+	el, ok := mc.entries[manifestImageKey(registryID, repositoryName, digest)]
+	if !ok {
+		return Image{}, false
+	}
+	mc.order.MoveToFront(el)
+	return *el.Value.(*manifestEntry).image, true
+}
+
+// put caches the image by its digest, and the digest and ETag of the reference when it is a tag
+func (mc *ManifestCache) put(registryID string, repositoryName string, reference string, img Image, etag string) {
+	digest := img.Digest
+	if isDigest(reference) {
+		digest = reference
+	}
+	if digest == "" {
+		return
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+	mc.set(manifestImageKey(registryID, repositoryName, digest), &manifestEntry{image: &img})
+	if !isDigest(reference) {
+		mc.set(manifestTagKey(registryID, repositoryName, reference), &manifestEntry{tag: &cachedTag{Digest: digest, ETag: etag}})
+	}
+}
+
+// set adds or replaces an entry and drops the least recently used entries beyond ManifestCacheSize,
+// the caller must hold the lock
+func (mc *ManifestCache) set(key string, entry *manifestEntry) {
+	entry.key = key
+	if el, ok := mc.entries[key]; ok {
+		el.Value = entry
+		mc.order.MoveToFront(el)
+		return
+	}
+	mc.entries[key] = mc.order.PushFront(entry)
+	for ManifestCacheSize > 0 && mc.order.Len() > ManifestCacheSize {
+		mc.remove(mc.order.Back())
+	}
+}
+
+// remove drops an entry, the caller must hold the lock
+func (mc *ManifestCache) remove(el *list.Element) {
+	mc.order.Remove(el)
+	delete(mc.entries, el.Value.(*manifestEntry).key)
+}
+
+// forget drops every entry with the key prefix
+func (mc *ManifestCache) forget(prefix string) {
+	mc.Lock()
+	defer mc.Unlock()
+	for el := mc.order.Front(); el != nil; {
+		next := el.Next()
+		if strings.HasPrefix(el.Value.(*manifestEntry).key, prefix) {
+			mc.remove(el)
+		}
+		el = next
+	}
+}
+
+// cachedImage returns the image for the reference from the cache when it is still current. Digest references
+// never change, tags are revalidated with a HEAD request sending the cached ETag in If-None-Match.
+func cachedImage(ctx context.Context, r Registry, c *RegistryClient, repositoryName string, reference string) (Image, bool) {
+	if isDigest(reference) {
+		return Manifests.image(r.ID, repositoryName, reference)
+	}
+
+	tag, ok := Manifests.tag(r.ID, repositoryName, reference)
+	if !ok {
+		return Image{}, false
+	}
+	img, ok := Manifests.image(r.ID, repositoryName, tag.Digest)
+	if !ok {
+		return Image{}, false
+	}
+
+	req, err := http.NewRequest("HEAD", r.GetURI()+"/"+repositoryName+"/manifests/"+reference, nil)
+	if err != nil {
+		return Image{}, false
+	}
+	req.Header.Set("Accept", strings.Join(ManifestAcceptTypes, ", "))
+	if tag.ETag != "" {
+		req.Header.Set("If-None-Match", tag.ETag)
+	}
+	response, err := c.Do(ctx, req)
+	if err != nil {
+		return Image{}, false
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotModified:
+		return img, true
+	case response.StatusCode == http.StatusOK && response.Header.Get("Docker-Content-Digest") == tag.Digest:
+		return img, true
+	}
+	return Image{}, false
+}
+
+// isDigest reports whether a manifest reference is a digest rather than a tag
+func isDigest(reference string) bool {
+	return strings.Contains(reference, ":")
+}
+
+func manifestTagKey(registryID string, repositoryName string, tagName string) string {
+	return registryID + keySeparator + repositoryName + keySeparator + "tag" + keySeparator + tagName
+}
+
+func manifestImageKey(registryID string, repositoryName string, digest string) string {
+	return registryID + keySeparator + repositoryName + keySeparator + "digest" + keySeparator + digest
+}
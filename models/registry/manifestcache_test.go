@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestManifestRevalidation checks that a tag's manifest is only fetched again once its digest has changed
+func TestManifestRevalidation(t *testing.T) {
+
+	digest := "sha256:manifest"
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/test/manifests/latest":
+			requests[req.Method]++
+			etag := `"` + digest + `"`
+			if req.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Type", MediaTypeManifestV2)
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(testManifestV2))
+		case "/v2/test/blobs/sha256:config":
+			w.Write([]byte(testImageConfig))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	stats := &FetchStats{}
+	ctx := WithFetchStats(context.Background(), stats)
+
+	Convey("The first request for a tag should fetch its manifest", t, func() {
+		img, err := GetImage(ctx, r.Alias, "test", "latest")
+		So(err, ShouldBeNil)
+		So(img.Digest, ShouldEqual, digest)
+		So(requests["GET"], ShouldEqual, 1)
+		So(stats.Fetched, ShouldEqual, 1)
+	})
+
+	Convey("An unchanged tag should be revalidated with its ETag instead of fetched", t, func() {
+		img, err := GetImage(ctx, r.Alias, "test", "latest")
+		So(err, ShouldBeNil)
+		So(img.Tag, ShouldEqual, "latest")
+		So(img.OS, ShouldNotBeEmpty)
+		So(requests["HEAD"], ShouldEqual, 1)
+		So(requests["GET"], ShouldEqual, 1)
+		So(stats.Revalidated, ShouldEqual, 1)
+	})
+
+	Convey("A tag pointing at a new digest should be fetched again", t, func() {
+		digest = "sha256:pushed"
+		img, err := GetImage(ctx, r.Alias, "test", "latest")
+		So(err, ShouldBeNil)
+		So(img.Digest, ShouldEqual, "sha256:pushed")
+		So(requests["GET"], ShouldEqual, 2)
+		So(stats.Fetched, ShouldEqual, 2)
+	})
+
+	Convey("Removing the registry should drop its cached manifests", t, func() {
+		Registries.Remove(r.ID)
+		_, ok := Manifests.tag(r.ID, "test", "latest")
+		So(ok, ShouldBeFalse)
+	})
+}
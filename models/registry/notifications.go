@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stefannaglee/docker-registry-manager/utilities"
+)
+
+// MediaTypeEvents is the content type of the event envelopes a registry POSTs to its notification endpoints
+const MediaTypeEvents = "application/vnd.docker.distribution.events.v1+json"
+
+// Notification event actions sent by the registry
+const (
+	EventActionPush   = "push"
+	EventActionPull   = "pull"
+	EventActionMount  = "mount"
+	EventActionDelete = "delete"
+)
+
+// NotificationToken is the bearer token registries must send to the notification endpoint, which is disabled when it is empty
+var NotificationToken string
+
+// ErrNotificationsDisabled is returned when a notification is received without a NotificationToken set
+var ErrNotificationsDisabled = errors.New("Notifications are disabled, start the manager with -notification-token")
+
+// ErrUnauthorized is returned when a notification doesn't carry the NotificationToken
+var ErrUnauthorized = errors.New("The notification token is missing or invalid")
+
+// Notification is the envelope of events a registry sends to its notification endpoints
+type Notification struct {
+	Events []NotificationEvent `json:"events"`
+
+	// Skipped is the number of events left out of Events because their action is unknown
+	Skipped int `json:"-"`
+}
+
+// NotificationEvent is a single push, pull, mount or delete of a manifest or blob in a registry
+type NotificationEvent struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Target    struct {
+		MediaType  string `json:"mediaType"`
+		Size       int64  `json:"size"`
+		Digest     string `json:"digest"`
+		Length     int64  `json:"length"`
+		Repository string `json:"repository"`
+		URL        string `json:"url"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		ID        string `json:"id"`
+		Addr      string `json:"addr"`
+		Host      string `json:"host"`
+		Method    string `json:"method"`
+		UserAgent string `json:"useragent"`
+	} `json:"request"`
+	Actor struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+	Source struct {
+		Addr       string `json:"addr"`
+		InstanceID string `json:"instanceID"`
+	} `json:"source"`
+}
+
+// Authorize checks the Authorization header of a notification against the NotificationToken,
+// which must be sent with the Bearer scheme
+func Authorize(header string) error {
+	if NotificationToken == "" {
+		return ErrNotificationsDisabled
+	}
+	const scheme = "Bearer "
+	if len(header) < len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return ErrUnauthorized
+	}
+	token := strings.TrimSpace(header[len(scheme):])
+	if subtle.ConstantTimeCompare([]byte(token), []byte(NotificationToken)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// ParseNotification decodes and validates an event envelope. Events with an action added by a newer
+// registry are skipped rather than rejected, so the rest of the envelope isn't sent again.
+func ParseNotification(body []byte) (Notification, error) {
+	n := Notification{}
+	if err := json.Unmarshal(body, &n); err != nil {
+		return n, errors.New("Invalid event envelope: " + err.Error())
+	}
+	if len(n.Events) == 0 {
+		return n, errors.New("Invalid event envelope: no events")
+	}
+
+	events := []NotificationEvent{}
+	for _, e := range n.Events {
+		switch e.Action {
+		case EventActionPush, EventActionPull, EventActionMount, EventActionDelete:
+		default:
+			utils.Log.WithFields(logrus.Fields{
+				"Event":  e.ID,
+				"Action": e.Action,
+			}).Warn("Skipping a notification with an unknown action")
+			n.Skipped++
+			continue
+		}
+		if e.Target.Repository == "" {
+			return n, errors.New("Invalid event " + e.ID + ": no target repository")
+		}
+		events = append(events, e)
+	}
+	n.Events = events
+	return n, nil
+}
+
+// IsManifest reports whether the event is about a manifest rather than a blob
+func (e NotificationEvent) IsManifest() bool {
+	if e.Target.URL != "" {
+		return strings.Contains(e.Target.URL, "/manifests/")
+	}
+	for _, mediaType := range ManifestAcceptTypes {
+		if e.Target.MediaType == mediaType {
+			return true
+		}
+	}
+	return e.Target.Tag != ""
+}
+
+// Registry returns the registry the event came from. It is found by the host of the target URL,
+// then the host the request was sent to and then the address of the registry instance.
+func (e NotificationEvent) Registry() (Registry, bool) {
+	registries := Registries.List()
+
+	if u, err := url.Parse(e.Target.URL); err == nil && u.Host != "" {
+		for _, r := range registries {
+			if r.Scheme == u.Scheme && matchesHost(r, u.Host) && strings.HasPrefix(u.Path, r.Path+"/v2/") {
+				return r, true
+			}
+		}
+	}
+	for _, host := range []string{e.Request.Host, e.Source.Addr} {
+		if host == "" {
+			continue
+		}
+		for _, r := range registries {
+			if matchesHost(r, host) {
+				return r, true
+			}
+		}
+	}
+	return Registry{}, false
+}
+
+// matchesHost reports whether host, with or without a port, is the host of the registry
+func matchesHost(r Registry, host string) bool {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.Trim(host, "[]"), ""
+	}
+	if !strings.EqualFold(name, r.Name) {
+		return false
+	}
+	if port == "" || r.Port == "" {
+		return port == r.Port || port == defaultPorts[r.Scheme] || r.Port == defaultPorts[r.Scheme]
+	}
+	return port == r.Port
+}
+
+// ApplyNotificationEvent drops the cached manifests a push or delete from the registry replaced, and updates
+// the metadata index when it is enabled, so the change shows up without waiting for the next crawl.
+// Pulls, mounts and blob pushes don't change what's cached or indexed. Deletes don't say whether they
+// were for a manifest or a blob, so the tags with the deleted digest are removed.
+func ApplyNotificationEvent(ctx context.Context, r Registry, e NotificationEvent) error {
+	repo := r.NormalizeRepository(e.Target.Repository)
+
+	switch e.Action {
+	case EventActionPush:
+		if e.Target.Tag == "" || !e.IsManifest() {
+			return nil
+		}
+		Manifests.Forget(r.ID, repo, e.Target.Tag)
+		if MetadataIndex == nil {
+			return nil
+		}
+		tag := TagForView{Name: e.Target.Tag, Digest: e.Target.Digest}
+		if !r.IsPublic() {
+			var err error
+			if tag, err = GetTag(ctx, r.Alias, repo, e.Target.Tag); err != nil {
+				return err
+			}
+		}
+		return MetadataIndex.PutTag(r.ID, repo, tag)
+
+	case EventActionDelete:
+		if e.Target.Tag != "" {
+			Manifests.Forget(r.ID, repo, e.Target.Tag)
+		}
+		if e.Target.Digest != "" {
+			Manifests.ForgetDigest(r.ID, repo, e.Target.Digest)
+		}
+		if MetadataIndex == nil {
+			return nil
+		}
+		removed, err := MetadataIndex.DeleteTags(r.ID, repo, e.Target.Tag, e.Target.Digest)
+		if err == nil {
+			utils.Log.WithFields(logrus.Fields{
+				"Registry":   r.Alias,
+				"Repository": repo,
+				"Digest":     e.Target.Digest,
+				"Removed":    removed,
+			}).Debug("Removed deleted tags from the metadata index")
+		}
+		return err
+	}
+	return nil
+}
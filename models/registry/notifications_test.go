@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testNotification = `{
+	"events": [{
+		"id": "push-1",
+		"action": "push",
+		"target": {
+			"mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+			"digest": "sha256:manifest",
+			"repository": "test",
+			"url": "%s/v2/test/manifests/sha256:manifest",
+			"tag": "latest"
+		},
+		"request": {"host": "registry.internal:5000"},
+		"source": {"addr": "registry-1:5000"}
+	}]
+}`
+
+// TestParseNotification checks that event envelopes are validated
+func TestParseNotification(t *testing.T) {
+
+	Convey("A push event should be decoded with its target", t, func() {
+		n, err := ParseNotification([]byte(testNotification))
+		So(err, ShouldBeNil)
+		So(len(n.Events), ShouldEqual, 1)
+		So(n.Events[0].Target.Tag, ShouldEqual, "latest")
+		So(n.Events[0].IsManifest(), ShouldBeTrue)
+	})
+
+	Convey("Envelopes without events or without a repository should be an error", t, func() {
+		_, err := ParseNotification([]byte(`{"events": []}`))
+		So(err, ShouldNotBeNil)
+		_, err = ParseNotification([]byte(`{"events": [{"action": "push", "target": {}}]}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Events with unknown actions should be skipped and counted", t, func() {
+		n, err := ParseNotification([]byte(`{"events": [{"action": "rename", "target": {"repository": "test"}}, {"action": "pull", "target": {"repository": "test"}}]}`))
+		So(err, ShouldBeNil)
+		So(len(n.Events), ShouldEqual, 1)
+		So(n.Events[0].Action, ShouldEqual, EventActionPull)
+		So(n.Skipped, ShouldEqual, 1)
+	})
+
+	Convey("Only the configured bearer token should be authorized", t, func() {
+		NotificationToken = ""
+		So(Authorize("Bearer secret"), ShouldEqual, ErrNotificationsDisabled)
+		NotificationToken = "secret"
+		defer func() { NotificationToken = "" }()
+		So(Authorize("Bearer secret"), ShouldBeNil)
+		So(Authorize("Bearer other"), ShouldEqual, ErrUnauthorized)
+		So(Authorize(""), ShouldEqual, ErrUnauthorized)
+		So(Authorize("secret"), ShouldEqual, ErrUnauthorized)
+		So(Authorize("Basic secret"), ShouldEqual, ErrUnauthorized)
+	})
+}
+
+// TestApplyNotification checks that events are matched to their registry and update the metadata index
+func TestApplyNotification(t *testing.T) {
+
+	server, r := newTestRegistry(t)
+	defer server.Close()
+	defer Registries.Remove(r.ID)
+
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ix, err := OpenIndex(filepath.Join(dir, "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.Close()
+	MetadataIndex = ix
+	defer func() { MetadataIndex = nil }()
+
+	n, err := ParseNotification([]byte(fmt.Sprintf(testNotification, server.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := n.Events[0]
+
+	Convey("The event should be matched to the registry by its target URL", t, func() {
+		matched, ok := push.Registry()
+		So(ok, ShouldBeTrue)
+		So(matched.ID, ShouldEqual, r.ID)
+	})
+
+	Convey("Events from an unknown registry should not be matched", t, func() {
+		unknown := push
+		unknown.Target.URL = ""
+		_, ok := unknown.Registry()
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("A push should add the tag to the index straight away", t, func() {
+		So(ApplyNotificationEvent(context.Background(), r, push), ShouldBeNil)
+		tags, ok := ix.Tags(r.ID, "test")
+		So(ok, ShouldBeTrue)
+		So(len(tags), ShouldEqual, 1)
+		So(tags[0].Digest, ShouldEqual, "sha256:manifest")
+
+		repo, _ := ix.repository(r.ID, "test")
+		So(repo.TagCount, ShouldEqual, 1)
+	})
+
+	Convey("A delete of the manifest should remove the tags pointing at it", t, func() {
+		del := NotificationEvent{Action: EventActionDelete}
+		del.Target.Repository = "test"
+		del.Target.Digest = "sha256:manifest"
+		So(ApplyNotificationEvent(context.Background(), r, del), ShouldBeNil)
+		tags, _ := ix.Tags(r.ID, "test")
+		So(len(tags), ShouldEqual, 0)
+	})
+}
+
+// TestApplyNotificationWithoutIndex checks that pushes and deletes drop the cached manifests when the metadata index is disabled
+func TestApplyNotificationWithoutIndex(t *testing.T) {
+
+	server, r := newTestRegistry(t)
+	defer server.Close()
+	defer Registries.Remove(r.ID)
+
+	n, err := ParseNotification([]byte(fmt.Sprintf(testNotification, server.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := n.Events[0]
+
+	Convey("A push should drop the cached digest of the tag", t, func() {
+		_, err := GetImage(context.Background(), r.Alias, "test", "latest")
+		So(err, ShouldBeNil)
+		_, ok := Manifests.tag(r.ID, "test", "latest")
+		So(ok, ShouldBeTrue)
+
+		So(ApplyNotificationEvent(context.Background(), r, push), ShouldBeNil)
+		_, ok = Manifests.tag(r.ID, "test", "latest")
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("A delete of the manifest should drop it and the tags pointing at it", t, func() {
+		_, err := GetImage(context.Background(), r.Alias, "test", "latest")
+		So(err, ShouldBeNil)
+
+		del := NotificationEvent{Action: EventActionDelete}
+		del.Target.Repository = "test"
+		del.Target.Digest = "sha256:manifest"
+		So(ApplyNotificationEvent(context.Background(), r, del), ShouldBeNil)
+		_, ok := Manifests.tag(r.ID, "test", "latest")
+		So(ok, ShouldBeFalse)
+		_, ok = Manifests.image(r.ID, "test", "sha256:manifest")
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"errors"
+	"strings"
+)
+
+// Registry kinds, a private registry lists its repositories from the catalog while a public
+// registry such as Docker Hub has no catalog and only shows the repositories it watches
+const (
+	KindPrivate = ""
+	KindPublic  = "public"
+)
+
+// DockerHubHost is the host serving the registry API of Docker Hub
+const DockerHubHost = "registry-1.docker.io"
+
+// dockerHubAliases contains the hosts people use for Docker Hub that don't serve the registry API
+var dockerHubAliases = map[string]bool{
+	"docker.io":               true,
+	"index.docker.io":         true,
+	"hub.docker.com":          true,
+	"registry.hub.docker.com": true,
+	DockerHubHost:             true,
+}
+
+// ErrReadOnly is returned when trying to change a public registry
+var ErrReadOnly = errors.New("The registry is a public read-only registry")
+
+// IsPublic reports whether the registry is a public read-only registry without a catalog
+func (r Registry) IsPublic() bool {
+	return r.Kind == KindPublic
+}
+
+// IsDockerHub reports whether the registry is Docker Hub
+func (r Registry) IsDockerHub() bool {
+	return r.Name == DockerHubHost
+}
+
+// CanDelete reports whether deletes should be offered for the registry
+func (r Registry) CanDelete() bool {
+	return !r.IsPublic() && r.Capabilities.CanDelete()
+}
+
+// CanListRepositories reports whether the repositories of the registry can be shown,
+// either from the catalog or from the watched repositories of a public registry
+func (r Registry) CanListRepositories() bool {
+	return r.IsPublic() || r.Capabilities.CanListCatalog()
+}
+
+// NormalizeRepository returns the full name of a repository in the registry.
+// Official images on Docker Hub live under library/, e.g alpine is library/alpine
+func (r Registry) NormalizeRepository(name string) string {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if r.IsDockerHub() && name != "" && !strings.Contains(name, "/") {
+		return "library/" + name
+	}
+	return name
+}
+
+// ParseRepositoryList splits a comma, space or newline separated list of repositories
+func ParseRepositoryList(list string) []string {
+	return strings.FieldsFunc(list, func(c rune) bool {
+		return c == ',' || c == ' ' || c == '\n' || c == '\r' || c == '\t'
+	})
+}
+
+// SetRepositories sets the watched repositories of the registry, normalized and without duplicates
+func (r *Registry) SetRepositories(names []string) {
+	seen := make(map[string]bool, len(names))
+	r.Repositories = nil
+	for _, name := range names {
+		name = r.NormalizeRepository(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		r.Repositories = append(r.Repositories, name)
+	}
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestParsePublicRegistry checks that Docker Hub hosts become a public registry with library/ applied to official images
+func TestParsePublicRegistry(t *testing.T) {
+
+	r, err := ParseRegistry("https://docker.io?repositories=alpine+grafana/grafana+alpine")
+	Convey("Docker Hub should be a public registry served from its API host", t, func() {
+		So(err, ShouldBeNil)
+		So(r.IsPublic(), ShouldBeTrue)
+		So(r.GetURI(), ShouldEqual, "https://registry-1.docker.io/v2")
+	})
+
+	Convey("The watched repositories should be normalized without duplicates", t, func() {
+		So(r.Repositories, ShouldResemble, []string{"library/alpine", "grafana/grafana"})
+	})
+
+	other, err := ParseRegistry("https://ghcr.io/v2?kind=public&repositories=org/app+tool")
+	Convey("Other public registries should not get the library/ prefix", t, func() {
+		So(err, ShouldBeNil)
+		So(other.IsPublic(), ShouldBeTrue)
+		So(other.Repositories, ShouldResemble, []string{"org/app", "tool"})
+	})
+
+	_, err = ParseRegistry("https://ghcr.io/v2?kind=mirror")
+	Convey("An unknown kind should be an error", t, func() {
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// TestPublicRegistryRepositories checks that a public registry lists its watched repositories without a catalog
+func TestPublicRegistryRepositories(t *testing.T) {
+
+	var catalog int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v2/_catalog" {
+			atomic.AddInt32(&catalog, 1)
+		}
+		http.NotFound(w, req)
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2?kind=public&repositories=team/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	repositories, err := GetRepositoriesFromRegistry(context.Background(), r.Alias)
+	Convey("The watched repositories should be listed without requesting the catalog", t, func() {
+		So(err, ShouldBeNil)
+		So(repositories.Repositories, ShouldResemble, []string{"team/app"})
+		So(atomic.LoadInt32(&catalog), ShouldEqual, 0)
+	})
+
+	_, err = DeleteTag(context.Background(), r.Alias, "team/app", "latest")
+	Convey("Deleting from a public registry should be refused", t, func() {
+		So(err, ShouldEqual, ErrReadOnly)
+		So(r.CanDelete(), ShouldBeFalse)
+	})
+}
+
+// TestRateLimit checks that the rate limit headers are recorded and a 429 pauses requests
+func TestRateLimit(t *testing.T) {
+
+	var requests, limited int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("RateLimit-Limit", "100;w=21600")
+		w.Header().Set("RateLimit-Remaining", "0;w=21600")
+		if atomic.LoadInt32(&limited) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewRegistryClient(r)
+
+	Convey("The limit reported by the registry should be recorded", t, func() {
+		response, err := c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(c.RateLimit().Limit, ShouldEqual, 100)
+		So(c.RateLimit().Remaining, ShouldEqual, 0)
+		So(c.RateLimit().Window.Hours(), ShouldEqual, 6)
+		So(c.RateLimit().Paused(), ShouldBeFalse)
+	})
+
+	Convey("After a 429 requests should fail fast until the Retry-After has passed", t, func() {
+		atomic.StoreInt32(&limited, 1)
+		response, err := c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(response.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		So(c.RateLimit().Paused(), ShouldBeTrue)
+
+		atomic.StoreInt32(&requests, 0)
+		_, err = c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldEqual, ErrRateLimited)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+	})
+}
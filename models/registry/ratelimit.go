@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitPause is how long requests are paused after a registry responds with 429 Too Many Requests
+// without saying when to retry
+var RateLimitPause = time.Minute
+
+// ErrRateLimited is returned without contacting the registry while it is rate limiting us
+var ErrRateLimited = errors.New("The registry rate limit has been reached, requests are paused until it resets")
+
+// RateLimit contains the pull limit a registry reported in its RateLimit-Limit and RateLimit-Remaining headers
+// e.g Docker Hub responds with RateLimit-Limit: 100;w=21600 and RateLimit-Remaining: 76;w=21600
+type RateLimit struct {
+	Limit     int           `json:"limit"`
+	Remaining int           `json:"remaining"`
+	Window    time.Duration `json:"window"`
+	Updated   time.Time     `json:"updated"`
+
+	// PausedUntil is set when the registry responded with 429 Too Many Requests
+	PausedUntil time.Time `json:"paused_until,omitempty"`
+}
+
+// Known reports whether the registry has reported a rate limit
+func (l RateLimit) Known() bool {
+	return !l.Updated.IsZero()
+}
+
+// Paused reports whether requests to the registry are paused
+func (l RateLimit) Paused() bool {
+	return time.Now().Before(l.PausedUntil)
+}
+
+// rateLimiter keeps track of the rate limit of a registry and pauses requests once it is reached
+type rateLimiter struct {
+	sync.Mutex
+	limit RateLimit
+}
+
+// allow reports whether a request may be sent to the registry
+func (l *rateLimiter) allow() bool {
+	l.Lock()
+	defer l.Unlock()
+	return !l.limit.Paused()
+}
+
+// update records the rate limit headers of the response and pauses requests if it was rate limited
+func (l *rateLimiter) update(response *http.Response) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if limit, window, ok := parseRateLimitHeader(response.Header.Get("RateLimit-Limit")); ok {
+		l.limit.Limit = limit
+		l.limit.Window = window
+		l.limit.Updated = now
+	}
+	if remaining, _, ok := parseRateLimitHeader(response.Header.Get("RateLimit-Remaining")); ok {
+		l.limit.Remaining = remaining
+		l.limit.Updated = now
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		l.limit.PausedUntil = now.Add(retryAfter(response.Header.Get("Retry-After"), now))
+	}
+}
+
+// parseRateLimitHeader parses a rate limit header value with an optional window in seconds, e.g 100;w=21600
+func parseRateLimitHeader(value string) (int, time.Duration, bool) {
+	if value == "" {
+		return 0, 0, false
+	}
+	parts := strings.Split(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var window time.Duration
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if strings.HasPrefix(p, "w=") {
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(p, "w=")); err == nil {
+				window = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return n, window, true
+}
+
+// retryAfter returns how long to wait from a Retry-After header holding seconds or an HTTP date,
+// falling back to RateLimitPause
+func retryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return RateLimitPause
+}
+
+// RateLimit returns the rate limit the registry last reported
+func (c *RegistryClient) RateLimit() RateLimit {
+	c.limiter.Lock()
+	defer c.limiter.Unlock()
+	return c.limiter.limit
+}
+
+// RateLimit returns the rate limit the registry last reported, it is empty for registries without one
+func (r Registry) RateLimit() RateLimit {
+	c, err := r.Client()
+	if err != nil {
+		return RateLimit{}
+	}
+	return c.RateLimit()
+}
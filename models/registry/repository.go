@@ -1,16 +1,21 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/stefannaglee/docker-registry-manager/utilities"
 )
 
+// CatalogPageSize is the number of repositories requested per page of the catalog
+var CatalogPageSize = 100
+
 // RepositoriesList contains a slice of all repositories
 type RepositoriesList struct {
 	Repositories []string
@@ -22,12 +27,16 @@ type Repository struct {
 	Registry   string
 	EncodedURI string
 	TagCount   int
+
+	// Refreshed is when the repository was last crawled into the metadata index, zero if it was requested live
+	Refreshed    time.Time
+	RefreshedAgo string
 }
 
 // GetRepositories returns a slice of repositories with their names and encoded names
-func GetRepositories(registryName string) []Repository {
+func GetRepositories(ctx context.Context, registryName string) []Repository {
 	cleanedRepos := []Repository{}
-	repos, _ := GetRepositoriesFromRegistry(registryName)
+	repos, _ := GetRepositoriesFromRegistry(ctx, registryName)
 	for _, value := range repos.Repositories {
 		r := Repository{}
 		r.EncodedURI = url.QueryEscape(value)
@@ -39,38 +48,84 @@ func GetRepositories(registryName string) []Repository {
 }
 
 // GetRepositoriesFromRegistry returns a slice of repositories for this registry name
-func GetRepositoriesFromRegistry(registryName string) (RepositoriesList, error) {
+//
+// The catalog is paginated by the registry, so each page is requested until the
+// registry stops returning a Link header for the next page.
+// https://github.com/docker/distribution/blob/master/docs/spec/api.md#pagination
+// Public registries have no catalog, so their watched repositories are returned instead.
+func GetRepositoriesFromRegistry(ctx context.Context, registryName string) (RepositoriesList, error) {
 
 	// Check if the registry is listed as active
-	if _, ok := ActiveRegistries[registryName]; !ok {
-		return RepositoriesList{}, errors.New(registryName + " was not found within the active list of registries.")
+	r, c, err := getClient(registryName)
+	if err != nil {
+		return RepositoriesList{}, err
+	}
+	if r.IsPublic() {
+		return RepositoriesList{Repositories: append([]string{}, r.Repositories...)}, nil
+	}
+
+	n := CatalogPageSize
+	if n < 1 {
+		n = 100
+	}
+
+	rs := RepositoriesList{}
+	last := ""
+	next := r.GetURI() + "/_catalog?n=" + strconv.Itoa(n)
+	for next != "" {
+		page, link, err := getCatalogPage(ctx, c, next)
+		if err != nil {
+			return rs, err
+		}
+
+		// Stop if the registry returned the same page again instead of advancing through the catalog
+		if len(page.Repositories) == 0 || page.Repositories[len(page.Repositories)-1] == last {
+			break
+		}
+		rs.Repositories = append(rs.Repositories, page.Repositories...)
+		last = page.Repositories[len(page.Repositories)-1]
+
+		// Follow the Link header, falling back to the last parameter if the registry
+		// returned a full page without one
+		current := next
+		next = link
+		if next == "" && len(page.Repositories) == n {
+			next = r.GetURI() + "/_catalog?n=" + strconv.Itoa(n) + "&last=" + url.QueryEscape(last)
+		}
+		if next == current {
+			break
+		}
 	}
-	r := ActiveRegistries[registryName]
+
+	return rs, nil
+}
+
+// getCatalogPage requests a single page of the catalog and returns the next page URI if there is one
+func getCatalogPage(ctx context.Context, c *RegistryClient, uri string) (RepositoriesList, string, error) {
 
 	// Create and execute Get request for the catalog of repositores
 	// https://github.com/docker/distribution/blob/master/docs/spec/api.md#catalog
-	response, err := http.Get(r.GetURI() + "/_catalog")
+	response, err := c.Get(ctx, uri)
 	if err != nil {
 		utils.Log.WithFields(logrus.Fields{
-			"Registry URL": string(r.GetURI()),
+			"Registry URL": c.uri,
 			"Error":        err,
 			"Possible Fix": "Check to see if your registry is up, and serving on the correct port with 'docker ps'. ",
 		}).Error("Get request to registry failed for the /_catalog endpoint! Is your registry active?")
+		return RepositoriesList{}, "", err
 	}
 
+	// Close connection
+	defer response.Body.Close()
+
 	// Check Status code
 	if response.StatusCode != 200 {
 		utils.Log.WithFields(logrus.Fields{
-			"Error":       err,
 			"Status Code": response.StatusCode,
-			"Response":    response,
 		}).Error("Did not receive an ok status code!")
-		return RepositoriesList{}, err
+		return RepositoriesList{}, "", errors.New("Catalog request returned " + response.Status)
 	}
 
-	// Close connection
-	defer response.Body.Close()
-
 	// Read response into byte body
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
@@ -78,6 +133,7 @@ func GetRepositoriesFromRegistry(registryName string) (RepositoriesList, error)
 			"Error": err,
 			"Body":  body,
 		}).Error("Unable to read response into body!")
+		return RepositoriesList{}, "", err
 	}
 
 	rs := RepositoriesList{}
@@ -87,7 +143,8 @@ func GetRepositoriesFromRegistry(registryName string) (RepositoriesList, error)
 			"Error":         err,
 			"Response Body": string(body),
 		}).Error("Unable to unmarshal JSON!")
+		return RepositoriesList{}, "", err
 	}
 
-	return rs, err
+	return rs, NextLink(response), nil
 }
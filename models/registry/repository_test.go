@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestCatalogPagination checks that every page of the catalog is requested and that a registry repeating a page doesn't loop forever
+func TestCatalogPagination(t *testing.T) {
+	defer func(size int) { CatalogPageSize = size }(CatalogPageSize)
+	CatalogPageSize = 2
+
+	repeat := false
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/_catalog" {
+			http.NotFound(w, req)
+			return
+		}
+		requests++
+		switch {
+		case repeat:
+			w.Header().Set("Link", `</v2/_catalog?n=2&last=b>; rel="next"`)
+			w.Write([]byte(`{"repositories": ["c", "d"]}`))
+		case req.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/_catalog?n=2&last=b>; rel="next"`)
+			w.Write([]byte(`{"repositories": ["a", "b"]}`))
+		default:
+			w.Write([]byte(`{"repositories": ["c"]}`))
+		}
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	Convey("Each page of the catalog should be followed until the last one", t, func() {
+		repos, err := GetRepositoriesFromRegistry(context.Background(), r.Alias)
+		So(err, ShouldBeNil)
+		So(repos.Repositories, ShouldResemble, []string{"a", "b", "c"})
+		So(requests, ShouldEqual, 2)
+	})
+
+	Convey("A registry linking to the same page again should stop the pagination", t, func() {
+		repeat = true
+		requests = 0
+		repos, err := GetRepositoriesFromRegistry(context.Background(), r.Alias)
+		So(err, ShouldBeNil)
+		So(repos.Repositories, ShouldResemble, []string{"c", "d"})
+		So(requests, ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryAttempts is the number of times an idempotent request is sent before giving up
+var RetryAttempts = 3
+
+// RetryBaseDelay is the delay before the first retry, later retries back off exponentially up to RetryMaxDelay
+var RetryBaseDelay = 200 * time.Millisecond
+
+// RetryMaxDelay is the longest delay between two attempts of a request
+var RetryMaxDelay = 5 * time.Second
+
+// BreakerThreshold is the number of consecutive failed requests after which a registry is marked degraded
+var BreakerThreshold = 5
+
+// BreakerCooldown is how long requests to a degraded registry fail fast before one is let through to test it
+var BreakerCooldown = 30 * time.Second
+
+// ErrRegistryDegraded is returned without contacting the registry while its circuit breaker is open
+var ErrRegistryDegraded = errors.New("The registry is degraded after repeated failures, requests are paused until it recovers")
+
+// retryable reports whether the request can safely be sent again
+func retryable(req *http.Request) bool {
+	return req.Method == "GET" || req.Method == "HEAD"
+}
+
+// temporaryStatus reports whether the status code is a transient failure of the registry or a proxy in front of it
+func temporaryStatus(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+// backoff returns a random delay up to the exponential backoff for the attempt, starting from zero
+func backoff(attempt int) time.Duration {
+	d := RetryBaseDelay << uint(attempt)
+	if d <= 0 || d > RetryMaxDelay {
+		d = RetryMaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// sleep waits for the duration, returning early with the context error if it is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// breaker is a circuit breaker that opens after BreakerThreshold consecutive failures.
+// Once BreakerCooldown has passed a single request is allowed through, closing it again if it succeeds.
+type breaker struct {
+	sync.Mutex
+	failures int
+	openedAt time.Time
+	testing  bool
+}
+
+// allow reports whether a request may be sent to the registry
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.failures < BreakerThreshold {
+		return true
+	}
+	if b.testing || time.Since(b.openedAt) < BreakerCooldown {
+		return false
+	}
+	b.testing = true
+	return true
+}
+
+// success closes the breaker
+func (b *breaker) success() {
+	b.Lock()
+	defer b.Unlock()
+	b.failures = 0
+	b.testing = false
+}
+
+// failure counts a failed request, opening the breaker once the threshold is reached
+func (b *breaker) failure() {
+	b.Lock()
+	defer b.Unlock()
+	b.failures++
+	if b.failures >= BreakerThreshold {
+		b.openedAt = time.Now()
+	}
+	b.testing = false
+}
+
+// release gives back a request allowed by allow that ended without telling whether the registry is healthy,
+// e.g because it was cancelled, so the next request can test the registry instead
+func (b *breaker) release() {
+	b.Lock()
+	defer b.Unlock()
+	b.testing = false
+}
+
+// open reports whether requests are currently failing fast
+func (b *breaker) open() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.failures >= BreakerThreshold
+}
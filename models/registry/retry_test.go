@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestRetry checks that temporary failures are retried and repeated failures open the circuit breaker
+func TestRetry(t *testing.T) {
+	RetryBaseDelay = time.Millisecond
+	defer func() { RetryBaseDelay = 200 * time.Millisecond }()
+
+	var requests, failures int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r, err := ParseRegistry(ts.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("A GET is retried until the registry recovers", t, func() {
+		c, _ := NewRegistryClient(r)
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, 2)
+
+		response, err := c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(response.StatusCode, ShouldEqual, http.StatusOK)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 3)
+	})
+
+	Convey("A DELETE is not retried", t, func() {
+		c, _ := NewRegistryClient(r)
+		atomic.StoreInt32(&requests, 0)
+		atomic.StoreInt32(&failures, 1)
+
+		req, _ := http.NewRequest("DELETE", r.GetURI()+"/", nil)
+		response, err := c.Do(context.Background(), req)
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(response.StatusCode, ShouldEqual, http.StatusServiceUnavailable)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 1)
+	})
+
+	Convey("Repeated failures degrade the registry and later requests fail fast", t, func() {
+		c, _ := NewRegistryClient(r)
+		atomic.StoreInt32(&failures, 1000)
+
+		for i := 0; i < BreakerThreshold; i++ {
+			response, err := c.Get(context.Background(), r.GetURI()+"/")
+			So(err, ShouldBeNil)
+			response.Body.Close()
+		}
+		So(c.Degraded(), ShouldBeTrue)
+
+		atomic.StoreInt32(&requests, 0)
+		_, err := c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldEqual, ErrRegistryDegraded)
+		So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+	})
+}
+
+// TestBreakerCancelledProbe checks that a degraded registry can recover after the request testing it was cancelled
+func TestBreakerCancelledProbe(t *testing.T) {
+	RetryBaseDelay = time.Millisecond
+	BreakerCooldown = time.Millisecond
+	defer func() {
+		RetryBaseDelay = 200 * time.Millisecond
+		BreakerCooldown = 30 * time.Second
+	}()
+
+	var failing int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r, err := ParseRegistry(ts.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("A cancelled request testing a degraded registry lets the next one test it", t, func() {
+		c, _ := NewRegistryClient(r)
+		for i := 0; i < BreakerThreshold; i++ {
+			response, err := c.Get(context.Background(), r.GetURI()+"/")
+			So(err, ShouldBeNil)
+			response.Body.Close()
+		}
+		So(c.Degraded(), ShouldBeTrue)
+		atomic.StoreInt32(&failing, 0)
+		time.Sleep(2 * BreakerCooldown)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.Get(ctx, r.GetURI()+"/")
+		So(err, ShouldNotBeNil)
+		So(err, ShouldNotEqual, ErrRegistryDegraded)
+
+		response, err := c.Get(context.Background(), r.GetURI()+"/")
+		So(err, ShouldBeNil)
+		response.Body.Close()
+		So(response.StatusCode, ShouldEqual, http.StatusOK)
+		So(c.Degraded(), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,274 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registries contains all active registries identified by their ID
+var Registries = NewRegistryStore()
+
+// Registry change event types passed to the subscribers of a RegistryStore
+const (
+	RegistryAdded   = "added"
+	RegistryUpdated = "updated"
+	RegistryRemoved = "removed"
+)
+
+// reservedAliases can't be used as an alias because they clash with other routes under /registries/
+var reservedAliases = map[string]bool{"all": true, "add": true, "test": true, "health": true, "notifications": true}
+
+// validAlias matches aliases that can be used in URLs without escaping
+var validAlias = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// RegistryEvent describes a change made to a RegistryStore.
+// Previous holds the registry as it was before an update or removal.
+type RegistryEvent struct {
+	Type     string
+	Registry Registry
+	Previous Registry
+}
+
+// RegistryStore holds registries by their ID and is safe for concurrent use.
+// Every registry also has a unique alias which is used to find it from URLs.
+type RegistryStore struct {
+	sync.RWMutex
+	registries  map[string]Registry
+	subscribers []func(RegistryEvent)
+}
+
+// NewRegistryStore creates an empty RegistryStore
+func NewRegistryStore() *RegistryStore {
+	return &RegistryStore{registries: make(map[string]Registry)}
+}
+
+// Get returns the registry with the passed ID
+func (s *RegistryStore) Get(id string) (Registry, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	r, ok := s.registries[id]
+	return r, ok
+}
+
+// GetByAlias returns the registry with the passed alias
+func (s *RegistryStore) GetByAlias(alias string) (Registry, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for _, r := range s.registries {
+		if r.Alias == alias {
+			return r, true
+		}
+	}
+	return Registry{}, false
+}
+
+// List returns a copy of every registry sorted by alias
+func (s *RegistryStore) List() []Registry {
+	s.RLock()
+	list := make([]Registry, 0, len(s.registries))
+	for _, r := range s.registries {
+		list = append(list, r)
+	}
+	s.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Alias < list[j].Alias
+	})
+	return list
+}
+
+// Len returns the number of registries in the store
+func (s *RegistryStore) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.registries)
+}
+
+// Add adds the registry and returns it as stored. A registry without an ID is given a new one,
+// and a registry without an alias is given its host name, followed by its port if that is taken.
+func (s *RegistryStore) Add(r Registry) (Registry, error) {
+	s.Lock()
+	if r.ID == "" {
+		r.ID = newRegistryID()
+	}
+	if _, ok := s.registries[r.ID]; ok {
+		s.Unlock()
+		return r, errors.New("A registry with the ID " + r.ID + " already exists")
+	}
+	if r.Alias == "" {
+		r.Alias = s.defaultAlias(r)
+	}
+	if err := s.checkAlias(r); err != nil {
+		s.Unlock()
+		return r, err
+	}
+	s.registries[r.ID] = r
+	s.Unlock()
+
+	s.notify(RegistryEvent{Type: RegistryAdded, Registry: r})
+	return r, nil
+}
+
+// Update replaces the registry with the same ID, failing if it has been removed
+func (s *RegistryStore) Update(r Registry) error {
+	return s.Replace(r.ID, r)
+}
+
+// Replace replaces the registry with the passed ID, keeping its ID and its alias if the new one has none.
+// It fails if the registry has been removed or another registry already has the alias.
+func (s *RegistryStore) Replace(id string, r Registry) error {
+	s.Lock()
+	previous, ok := s.registries[id]
+	if !ok {
+		s.Unlock()
+		return errors.New(id + " was not found within the active list of registries.")
+	}
+	r.ID = id
+	if r.Alias == "" {
+		r.Alias = previous.Alias
+	}
+	if err := s.checkAlias(r); err != nil {
+		s.Unlock()
+		return err
+	}
+	s.registries[id] = r
+	s.Unlock()
+
+	s.notify(RegistryEvent{Type: RegistryUpdated, Registry: r, Previous: previous})
+	return nil
+}
+
+// Modify changes the registry with the passed ID in place using fn, so fields set elsewhere
+// in the meantime aren't overwritten. The ID and alias can't be changed this way.
+// Subscribers are only notified when fn actually changed the registry.
+func (s *RegistryStore) Modify(id string, fn func(r *Registry)) error {
+	s.Lock()
+	previous, ok := s.registries[id]
+	if !ok {
+		s.Unlock()
+		return errors.New(id + " was not found within the active list of registries.")
+	}
+	r := previous
+	fn(&r)
+	r.ID = previous.ID
+	r.Alias = previous.Alias
+	if reflect.DeepEqual(r, previous) {
+		s.Unlock()
+		return nil
+	}
+	s.registries[id] = r
+	s.Unlock()
+
+	s.notify(RegistryEvent{Type: RegistryUpdated, Registry: r, Previous: previous})
+	return nil
+}
+
+// Remove removes the registry with the passed ID and returns it
+func (s *RegistryStore) Remove(id string) (Registry, error) {
+	s.Lock()
+	r, ok := s.registries[id]
+	if !ok {
+		s.Unlock()
+		return Registry{}, errors.New(id + " was not found within the active list of registries.")
+	}
+	delete(s.registries, id)
+	s.Unlock()
+
+	s.notify(RegistryEvent{Type: RegistryRemoved, Registry: r, Previous: r})
+	return r, nil
+}
+
+// defaultAlias returns the first free alias of the host name, the host name and port,
+// or the host name and port with a number. The store must be locked.
+func (s *RegistryStore) defaultAlias(r Registry) string {
+	base := strings.Map(func(c rune) rune {
+		if c == '.' || c == '-' || c == '_' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			return c
+		}
+		return '-'
+	}, strings.ToLower(r.Name))
+	if base == "" || !validAlias.MatchString(base) {
+		base = "registry"
+	}
+
+	candidates := []string{base, base + "-" + r.Port}
+	for i := 2; ; i++ {
+		for _, alias := range candidates {
+			if !reservedAliases[alias] && !s.aliasTaken(alias, r.ID) {
+				return alias
+			}
+		}
+		candidates = []string{base + "-" + r.Port + "-" + strconv.Itoa(i)}
+	}
+}
+
+// checkAlias returns an error if the alias of the registry can't be used. The store must be locked.
+func (s *RegistryStore) checkAlias(r Registry) error {
+	if !validAlias.MatchString(r.Alias) || reservedAliases[r.Alias] {
+		return errors.New("The alias " + r.Alias + " may only contain letters, numbers, '.', '-' and '_', and can't be " + reservedAliasList())
+	}
+	if s.aliasTaken(r.Alias, r.ID) {
+		return errors.New("A registry with the alias " + r.Alias + " already exists")
+	}
+	return nil
+}
+
+// reservedAliasList returns the reserved aliases in order for error messages, e.g "add, all or test"
+func reservedAliasList() string {
+	aliases := []string{}
+	for alias := range reservedAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	if len(aliases) < 2 {
+		return strings.Join(aliases, "")
+	}
+	return strings.Join(aliases[:len(aliases)-1], ", ") + " or " + aliases[len(aliases)-1]
+}
+
+// aliasTaken reports whether a registry other than the one with the passed ID has the alias. The store must be locked.
+func (s *RegistryStore) aliasTaken(alias string, id string) bool {
+	for _, other := range s.registries {
+		if other.Alias == alias && other.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// newRegistryID returns a random ID for a new registry
+func newRegistryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Subscribe registers a function that is called after every change to the store.
+// It is called outside of the store lock so it may use the store.
+func (s *RegistryStore) Subscribe(fn func(RegistryEvent)) {
+	s.Lock()
+	defer s.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// notify passes the event to each subscriber
+func (s *RegistryStore) notify(e RegistryEvent) {
+	s.RLock()
+	subscribers := make([]func(RegistryEvent), len(s.subscribers))
+	copy(subscribers, s.subscribers)
+	s.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestRegistryStore checks the store methods, notifications and concurrent use
+func TestRegistryStore(t *testing.T) {
+
+	Convey("Registries can be added, updated and removed", t, func() {
+		s := NewRegistryStore()
+		events := []string{}
+		s.Subscribe(func(e RegistryEvent) {
+			events = append(events, e.Type+" "+e.Registry.Alias)
+		})
+
+		b, err := s.Add(Registry{Name: "b", Port: "5000"})
+		So(err, ShouldBeNil)
+		a, err := s.Add(Registry{Name: "a", Port: "5000"})
+		So(err, ShouldBeNil)
+		_, err = s.Add(Registry{ID: a.ID, Name: "a", Port: "5000"})
+		So(err, ShouldNotBeNil)
+
+		a.Status = "available"
+		So(s.Update(a), ShouldBeNil)
+		So(s.Update(Registry{ID: "missing"}), ShouldNotBeNil)
+
+		r, ok := s.Get(a.ID)
+		So(ok, ShouldBeTrue)
+		So(r.Status, ShouldEqual, "available")
+
+		list := s.List()
+		So(len(list), ShouldEqual, 2)
+		So(list[0].Alias, ShouldEqual, "a")
+		So(list[1].Alias, ShouldEqual, "b")
+
+		So(s.Replace(a.ID, Registry{Alias: "b"}), ShouldNotBeNil)
+		So(s.Replace(a.ID, Registry{Alias: "c"}), ShouldBeNil)
+		r, ok = s.GetByAlias("c")
+		So(ok, ShouldBeTrue)
+		So(r.ID, ShouldEqual, a.ID)
+
+		_, err = s.Remove(b.ID)
+		So(err, ShouldBeNil)
+		_, ok = s.Get(b.ID)
+		So(ok, ShouldBeFalse)
+		So(s.Len(), ShouldEqual, 1)
+
+		So(events, ShouldResemble, []string{"added b", "added a", "updated a", "updated c", "removed b"})
+	})
+
+	Convey("Modifying a registry only notifies the subscribers when it changed", t, func() {
+		s := NewRegistryStore()
+		updates := 0
+		s.Subscribe(func(e RegistryEvent) {
+			if e.Type == RegistryUpdated {
+				updates++
+			}
+		})
+		r, err := s.Add(Registry{Name: "a", Port: "5000", Labels: map[string]string{"env": "prod"}})
+		So(err, ShouldBeNil)
+
+		So(s.Modify(r.ID, func(stored *Registry) { stored.Status = "available" }), ShouldBeNil)
+		So(s.Modify(r.ID, func(stored *Registry) { stored.Status = "available" }), ShouldBeNil)
+		So(updates, ShouldEqual, 1)
+	})
+
+	Convey("Registries on the same host get different aliases", t, func() {
+		s := NewRegistryStore()
+		staging, err := s.Add(Registry{Name: "Registry.Example.com", Port: "5000"})
+		So(err, ShouldBeNil)
+		prod, err := s.Add(Registry{Name: "registry.example.com", Port: "5001"})
+		So(err, ShouldBeNil)
+		again, err := s.Add(Registry{Name: "registry.example.com", Port: "5001"})
+		So(err, ShouldBeNil)
+
+		So(staging.Alias, ShouldEqual, "registry.example.com")
+		So(prod.Alias, ShouldEqual, "registry.example.com-5001")
+		So(again.Alias, ShouldEqual, "registry.example.com-5001-2")
+		So(staging.ID, ShouldNotEqual, prod.ID)
+
+		_, err = s.Add(Registry{Name: "host", Alias: "all"})
+		So(err, ShouldNotBeNil)
+		_, err = s.Add(Registry{Name: "host", Alias: "notifications"})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "add, all, health, notifications or test")
+		_, err = s.Add(Registry{Name: "host", Alias: "has/slash"})
+		So(err, ShouldNotBeNil)
+		_, err = s.Add(Registry{Name: "host", Alias: staging.Alias})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("The store can be used from many goroutines at once", t, func() {
+		s := NewRegistryStore()
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				r, err := s.Add(Registry{Name: "host", Port: strconv.Itoa(i)})
+				if err != nil {
+					return
+				}
+				r.Status = "available"
+				s.Update(r)
+				s.GetByAlias(r.Alias)
+				s.List()
+			}(i)
+		}
+		wg.Wait()
+		So(s.Len(), ShouldEqual, 50)
+	})
+}
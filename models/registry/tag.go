@@ -1,12 +1,15 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -14,6 +17,9 @@ import (
 	"github.com/stefannaglee/docker-registry-manager/utilities"
 )
 
+// TagPageSize is the number of tags requested per page of a repository tag list
+var TagPageSize = 100
+
 // Tags contains a slice of tags for the given repository
 // https://github.com/docker/distribution/blob/master/docs/spec/api.md#listing-image-tags
 type Tags struct {
@@ -21,15 +27,18 @@ type Tags struct {
 	Tags []string
 }
 
+// TagForView contains the information shown for a tag, with an entry in Platforms for each platform of a manifest list
 type TagForView struct {
 	ID              string
 	Name            string
+	Digest          string
 	UpdatedTime     time.Time
 	UpdatedTimeUnix int64
 	TimeAgo         string
 	Layers          int
 	Size            string
 	SizeInt         int64
+	Platforms       []TagForView
 }
 
 // TagsForView contains a slice of TagsForView with the methods required to sort
@@ -48,105 +57,141 @@ func (slice TagsForView) Swap(i, j int) {
 }
 
 // GetTagsForView returns the sanitized tag structs with the required information for the tags template
-func GetTagsForView(registryName string, repositoryName string) (TagsForView, error) {
-	tagObj, err := GetTags(registryName, repositoryName)
-	tags := TagsForView{}
+func GetTagsForView(ctx context.Context, registryName string, repositoryName string) (TagsForView, error) {
+	tagObj, err := GetTags(ctx, registryName, repositoryName)
+	if err != nil {
+		return nil, err
+	}
+	return getTagsForView(ctx, registryName, repositoryName, tagObj.Tags)
+}
+
+// GetTagsForViewPage returns the sanitized tag structs for a single page of at most n tags after last.
+// The returned string is the last parameter for the next page, or empty when this was the final page.
+func GetTagsForViewPage(ctx context.Context, registryName string, repositoryName string, n int, last string) (TagsForView, string, error) {
+	tagObj, next, err := GetTagsPage(ctx, registryName, repositoryName, n, last)
+	if err != nil {
+		return nil, "", err
+	}
+	tags, err := getTagsForView(ctx, registryName, repositoryName, tagObj.Tags)
+	return tags, next, err
+}
+
+// getTagsForView gets the image information for each of the passed tag names concurrently.
+// Tags whose image could not be requested are still returned, along with the first error.
+func getTagsForView(ctx context.Context, registryName string, repositoryName string, tagNames []string) (TagsForView, error) {
 
-	tagChan := make(chan TagForView)
+	type result struct {
+		tag TagForView
+		err error
+	}
+	tagChan := make(chan result)
 
 	// Loop through each tag to build the TagForView type
-	for _, tagName := range tagObj.Tags {
+	for _, tagName := range tagNames {
 
 		go func(tagName string) {
-			// Created a new tag for view type to fill
-			t := TagForView{}
-			var tempSize int64
-			var maxTime time.Time
-
 			// Get the image information for each tag
-			img, _ := GetImage(registryName, repositoryName, tagName)
-
-			for _, layer := range img.FsLayers {
-
-				// Check if the registry is listed as active
-				r := ActiveRegistries[registryName]
-				// Create and execute Get request
-				response, _ := http.Head(r.GetURI() + "/" + repositoryName + "/blobs/" + layer.BlobSum)
-				if err != nil {
-					utils.Log.Error(err)
-				}
-				tempSize += response.ContentLength
-			}
-			// Get the latest creation time and total the size for the tag image
-			for _, history := range img.History {
-				if history.V1Compatibility.Created.After(maxTime) {
-					maxTime = history.V1Compatibility.Created
-				}
-			}
-
-			// Set the fields
-			t.Size = bytefmt.ByteSize(uint64(tempSize))
-			t.SizeInt = tempSize
-			t.UpdatedTime = maxTime
-			t.UpdatedTimeUnix = maxTime.Unix()
-			t.Layers = len(img.History)
-			t.Name = tagName
-			t.TimeAgo = utils.TimeAgo(maxTime)
+			img, err := GetImage(ctx, registryName, repositoryName, tagName)
 
 			// Append to the tags list that will be passed to the template
-			tagChan <- t
+			tagChan <- result{NewTagForView(tagName, img), err}
 
 		}(tagName)
 
 	}
 
 	var TagInformation TagsForView
+	var err error
 	// Wait for each of the requests and append to the returned tag information
-	for i := 0; i < len(tagObj.Tags); i++ {
-		tag := <-tagChan
-		TagInformation = append(TagInformation, tag)
+	for i := 0; i < len(tagNames); i++ {
+		res := <-tagChan
+		if res.err != nil && err == nil {
+			err = res.err
+		}
+		TagInformation = append(TagInformation, res.tag)
 	}
 	close(tagChan)
-	sort.Sort(sort.Reverse(tags))
+	sort.Sort(sort.Reverse(TagInformation))
 
 	return TagInformation, err
 }
 
-// GetTags returns a slice of tags for a given repository and registry
-func GetTags(registryName string, repositoryName string) (Tags, error) {
+// GetTags returns a slice of all tags for a given repository and registry, following each page of the tag list
+func GetTags(ctx context.Context, registryName string, repositoryName string) (Tags, error) {
+
+	n := TagPageSize
+	if n < 1 {
+		n = 100
+	}
+
+	ts := Tags{}
+	seen := map[string]bool{}
+	last := ""
+	for {
+		page, next, err := GetTagsPage(ctx, registryName, repositoryName, n, last)
+		if err != nil {
+			return ts, err
+		}
+		ts.Name = page.Name
+
+		// Skip tags of a page the registry repeated so each tag is only returned once
+		added := 0
+		for _, tag := range page.Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				ts.Tags = append(ts.Tags, tag)
+				added++
+			}
+		}
+
+		// Stop on the last page, and on an empty or repeated page to avoid looping forever
+		if next == "" || next == last || added == 0 {
+			return ts, nil
+		}
+		last = next
+	}
+}
+
+// GetTagsPage returns a single page of at most n tags after last for a given repository and registry.
+// The returned string is the last parameter for the next page, or empty when this was the final page.
+// https://github.com/docker/distribution/blob/master/docs/spec/api.md#pagination
+func GetTagsPage(ctx context.Context, registryName string, repositoryName string, n int, last string) (Tags, string, error) {
 
 	repositoryName, _ = url.QueryUnescape(repositoryName)
 
 	// Check if the registry is listed as active
-	if _, ok := ActiveRegistries[registryName]; !ok {
-		return Tags{}, errors.New(registryName + " was not found within the active list of registries.")
+	r, c, err := getClient(registryName)
+	if err != nil {
+		return Tags{}, "", err
+	}
+
+	uri := r.GetURI() + "/" + repositoryName + "/tags/list?n=" + strconv.Itoa(n)
+	if last != "" {
+		uri += "&last=" + url.QueryEscape(last)
 	}
-	r := ActiveRegistries[registryName]
 
 	// Create and execute Get request
-	response, err := http.Get(r.GetURI() + "/" + repositoryName + "/tags/list")
+	response, err := c.Get(ctx, uri)
 	if err != nil {
 		utils.Log.WithFields(logrus.Fields{
 			"Registry URL": string(r.GetURI()),
 			"Error":        err,
 			"Possible Fix": "Check to see if your registry is up, and serving on the correct port with 'docker ps'. ",
 		}).Error("Get request to registry failed for the tags endpoint.")
-		return Tags{}, err
+		return Tags{}, "", err
 	}
 
+	// Close connection
+	defer response.Body.Close()
+
 	// Check Status code
 	if response.StatusCode != 200 {
 		utils.Log.WithFields(logrus.Fields{
-			"Error":       err,
 			"Status Code": response.StatusCode,
-			"Response":    response,
 		}).Error("Did not receive an ok status code!")
-		return Tags{}, err
+		return Tags{}, "", errors.New("Tag list request returned " + response.Status)
 	}
 
-	// Close connection
-	defer response.Body.Close()
-
 	// Read response into byte body
 	body, err := ioutil.ReadAll(response.Body)
 	if err != nil {
@@ -154,7 +199,7 @@ func GetTags(registryName string, repositoryName string) (Tags, error) {
 			"Error": err,
 			"Body":  body,
 		}).Error("Unable to read response into body!")
-		return Tags{}, err
+		return Tags{}, "", err
 	}
 	ts := Tags{}
 	// Unmarshal JSON into the tag response struct containing an array of tags
@@ -163,9 +208,20 @@ func GetTags(registryName string, repositoryName string) (Tags, error) {
 			"Error":         err,
 			"Response Body": string(body),
 		}).Error("Unable to unmarshal JSON!")
-		return ts, err
+		return ts, "", err
+	}
+
+	// Take the next page from the Link header, falling back to the last tag if the page was full
+	next := ""
+	if link := NextLink(response); link != "" {
+		if u, err := url.Parse(link); err == nil {
+			next = u.Query().Get("last")
+		}
+	} else if len(ts.Tags) == n {
+		next = ts.Tags[len(ts.Tags)-1]
 	}
-	return ts, nil
+
+	return ts, next, nil
 }
 
 // DeleteTag deletes the tag by first getting its docker-content-digest, and then using
@@ -173,107 +229,131 @@ func GetTags(registryName string, repositoryName string) (Tags, error) {
 //
 // Documentation:
 // DELETE	/v2/<name>/manifests/<reference>	Manifest	Delete the manifest identified by name and reference. Note that a manifest can only be deleted by digest.
-func DeleteTag(registryName string, repositoryName string, tag string) (bool, error) {
+func DeleteTag(ctx context.Context, registryName string, repositoryName string, tag string) (bool, error) {
 
 	repositoryName, _ = url.QueryUnescape(repositoryName)
 
 	// Check if the registry is listed as active
-	if _, ok := ActiveRegistries[registryName]; !ok {
-		return false, errors.New(registryName + " was not found within the active list of registries.")
+	r, c, err := getClient(registryName)
+	if err != nil {
+		return false, err
+	}
+	if r.IsPublic() {
+		return false, ErrReadOnly
 	}
-	r := ActiveRegistries[registryName]
 
 	// Check if the tag exists. If it does not we cannot get the digest from it
-	client := &http.Client{}
 	req, _ := http.NewRequest("HEAD", r.GetURI()+"/"+repositoryName+"/manifests/"+tag, nil)
 
 	// Note When deleting a manifest from a registry version 2.3 or later, the following header must be used when HEAD or GET-ing the manifest to obtain the correct digest to delete:
 	// Accept: application/vnd.docker.distribution.manifest.v2+json
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+	// Manifest lists and OCI formats are accepted too so their own digest is deleted
+	req.Header.Set("Accept", strings.Join(ManifestAcceptTypes, ", "))
 
 	// Execute the request
-	resp, existsErr := client.Do(req)
+	resp, existsErr := c.Do(ctx, req)
 	if existsErr != nil {
 		utils.Log.WithFields(logrus.Fields{
-			"Request":  resp.Request,
-			"Error":    existsErr,
-			"Tag":      tag,
-			"Response": resp,
+			"Error": existsErr,
+			"Tag":   tag,
 		}).Error("Could not delete tag! Could not head the tag.")
 		return false, existsErr
 	}
+	resp.Body.Close()
 
 	// Make sure the digest exists in the header. If it does, attempt the deletion
-	if _, ok := resp.Header["Docker-Content-Digest"]; ok {
-
-		if len(resp.Header["Docker-Content-Digest"]) > 0 {
-			// Create and execute DELETE request
-			digest := resp.Header["Docker-Content-Digest"][0]
-			client := &http.Client{}
-			req, _ := http.NewRequest("DELETE", r.GetURI()+"/"+repositoryName+"/manifests/"+digest, nil)
-			req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-			resp, err := client.Do(req)
-			if err != nil || resp.StatusCode != 200 {
-				utils.Log.WithFields(logrus.Fields{
-					"Error":    err,
-					"Tag":      tag,
-					"Response": resp,
-				}).Error("Could not delete tag!")
-				return false, err
-			}
-		}
-
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if resp.StatusCode != 200 || digest == "" {
 		// Error if there was nothing in the Docker-Content-Digest field
 		utils.Log.WithFields(logrus.Fields{
-			"Error":    errors.New("No digest gotten from response header"),
-			"Tag":      tag,
-			"Response": resp,
+			"Error":       errors.New("No digest gotten from response header"),
+			"Tag":         tag,
+			"Status Code": resp.StatusCode,
 		}).Error("Could not delete tag!")
 		return false, errors.New("No digest gotten from response header")
 	}
 
+	// Create and execute DELETE request, the registry responds with 202 Accepted
+	req, _ = http.NewRequest("DELETE", r.GetURI()+"/"+repositoryName+"/manifests/"+digest, nil)
+	resp, err = c.Do(ctx, req)
+	if err != nil {
+		utils.Log.WithFields(logrus.Fields{
+			"Error": err,
+			"Tag":   tag,
+		}).Error("Could not delete tag!")
+		return false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		err = errors.New("Delete request for " + tag + " returned " + resp.Status)
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			err = ErrDeleteDisabled
+			refreshCapabilities(ctx, r)
+		}
+		utils.Log.WithFields(logrus.Fields{
+			"Error":       err,
+			"Tag":         tag,
+			"Status Code": resp.StatusCode,
+		}).Error("Could not delete tag!")
+		return false, err
+	}
+
+	Manifests.Forget(r.ID, repositoryName, tag)
+	Activity.Record(ActivityEvent{
+		Registry:   r.ID,
+		Alias:      r.Alias,
+		Action:     EventActionDelete,
+		Actor:      r.Username,
+		Repository: repositoryName,
+		Tag:        tag,
+		Digest:     digest,
+		Source:     ActivitySourceManager,
+	})
+
 	return true, nil
 }
 
 // Get tag returns a TagForView based on the passed tag name
-func GetTag(registryName string, repositoryName string, tagName string) (TagForView, error) {
+func GetTag(ctx context.Context, registryName string, repositoryName string, tagName string) (TagForView, error) {
+
+	// Get the image information for the tag
+	img, err := GetImage(ctx, registryName, repositoryName, tagName)
+	if err != nil {
+		return TagForView{Name: tagName}, err
+	}
+
+	return NewTagForView(tagName, img), nil
+}
+
+// NewTagForView builds the TagForView for the passed image. Manifest lists get an entry in
+// Platforms for each platform, named by its os/arch/variant.
+func NewTagForView(tagName string, img Image) TagForView {
 
 	// Created a new tag for view type to fill
 	t := TagForView{}
-	var tempSize int64
 	var maxTime time.Time
 
-	// Get the image information for each tag
-	img, _ := GetImage(registryName, repositoryName, tagName)
-
-	for _, layer := range img.FsLayers {
-
-		// Check if the registry is listed as active
-		r := ActiveRegistries[registryName]
-		// Create and execute Get request
-		response, err := http.Head(r.GetURI() + "/" + repositoryName + "/blobs/" + layer.BlobSum)
-		if err != nil {
-			utils.Log.Error(err)
-			return t, err
-		}
-		tempSize += response.ContentLength
-	}
-	// Get the latest creation time and total the size for the tag image
+	// Get the latest creation time for the tag image
 	for _, history := range img.History {
 		if history.V1Compatibility.Created.After(maxTime) {
 			maxTime = history.V1Compatibility.Created
 		}
 	}
 
-	// Set the fields
-	t.Size = bytefmt.ByteSize(uint64(tempSize))
-	t.SizeInt = tempSize
+	// Set the fields, GetImage has already filled in the size of each layer
+	t.Size = bytefmt.ByteSize(uint64(img.Size()))
+	t.SizeInt = img.Size()
 	t.UpdatedTime = maxTime
 	t.UpdatedTimeUnix = maxTime.Unix()
 	t.Layers = len(img.History)
 	t.Name = tagName
+	t.Digest = img.Digest
 	t.TimeAgo = utils.TimeAgo(maxTime)
 
-	return t, nil
+	for _, p := range img.Platforms {
+		t.Platforms = append(t.Platforms, NewTagForView(p.PlatformName(), p))
+	}
 
+	return t
 }
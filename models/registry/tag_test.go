@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// TestTagPagination checks that every page of a tag list is requested once and that empty or repeated pages stop the pagination
+func TestTagPagination(t *testing.T) {
+	defer func(size int) { TagPageSize = size }(TagPageSize)
+	TagPageSize = 2
+
+	mode := "link"
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/repo/tags/list" {
+			http.NotFound(w, req)
+			return
+		}
+		requests++
+		last := req.URL.Query().Get("last")
+		switch mode {
+		case "link":
+			// Pages linked by the registry, with a short final page
+			switch last {
+			case "":
+				w.Header().Set("Link", `</v2/repo/tags/list?n=2&last=b>; rel="next"`)
+				w.Write([]byte(`{"name": "repo", "tags": ["a", "b"]}`))
+			case "b":
+				w.Header().Set("Link", `</v2/repo/tags/list?n=2&last=d>; rel="next"`)
+				w.Write([]byte(`{"name": "repo", "tags": ["c", "d"]}`))
+			default:
+				w.Write([]byte(`{"name": "repo", "tags": ["e"]}`))
+			}
+		case "full":
+			// Full pages without a Link header, ending with an empty page
+			switch last {
+			case "":
+				w.Write([]byte(`{"name": "repo", "tags": ["a", "b"]}`))
+			case "b":
+				w.Write([]byte(`{"name": "repo", "tags": ["c", "d"]}`))
+			default:
+				w.Write([]byte(`{"name": "repo", "tags": []}`))
+			}
+		case "repeat":
+			// A registry ignoring the last parameter and always serving the first page
+			w.Write([]byte(`{"name": "repo", "tags": ["a", "b"]}`))
+		}
+	}))
+	defer server.Close()
+
+	r, err := ParseRegistry(server.URL + "/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddRegistry(); err != nil {
+		t.Fatal(err)
+	}
+	defer Registries.Remove(r.ID)
+
+	Convey("A single page should return the last parameter of its Link header", t, func() {
+		mode = "link"
+		tags, next, err := GetTagsPage(context.Background(), r.Alias, "repo", 2, "")
+		So(err, ShouldBeNil)
+		So(tags.Tags, ShouldResemble, []string{"a", "b"})
+		So(next, ShouldEqual, "b")
+
+		tags, next, err = GetTagsPage(context.Background(), r.Alias, "repo", 2, "d")
+		So(err, ShouldBeNil)
+		So(tags.Tags, ShouldResemble, []string{"e"})
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("Each page linked by the registry should be followed until the last one", t, func() {
+		mode = "link"
+		requests = 0
+		tags, err := GetTags(context.Background(), r.Alias, "repo")
+		So(err, ShouldBeNil)
+		So(tags.Name, ShouldEqual, "repo")
+		So(tags.Tags, ShouldResemble, []string{"a", "b", "c", "d", "e"})
+		So(requests, ShouldEqual, 3)
+	})
+
+	Convey("Full pages without a Link header should be followed until an empty page", t, func() {
+		mode = "full"
+		requests = 0
+		tags, err := GetTags(context.Background(), r.Alias, "repo")
+		So(err, ShouldBeNil)
+		So(tags.Tags, ShouldResemble, []string{"a", "b", "c", "d"})
+		So(requests, ShouldEqual, 3)
+	})
+
+	Convey("A registry repeating a page should stop the pagination without duplicating tags", t, func() {
+		mode = "repeat"
+		requests = 0
+		tags, err := GetTags(context.Background(), r.Alias, "repo")
+		So(err, ShouldBeNil)
+		So(tags.Tags, ShouldResemble, []string{"a", "b"})
+		So(requests, ShouldEqual, 2)
+	})
+}
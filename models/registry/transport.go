@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// newTransport builds the pooled transport for a registry from its TLS and proxy settings and the configured timeouts
+func newTransport(r *Registry) (*http.Transport, error) {
+	tlsConfig, err := r.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := r.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   ConnectTimeout,
+		ResponseHeaderTimeout: ResponseTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}, nil
+}
+
+// TLSConfig builds the tls configuration for the registry from its CA bundle, client certificate and insecure setting
+func (r *Registry) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: r.TLSInsecureSkipVerify,
+	}
+
+	// Trust the passed CA bundle in addition to the system roots
+	if r.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(r.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates could be parsed from the CA file " + r.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	// Present a client certificate for registries that require mutual TLS
+	if r.TLSCertFile != "" || r.TLSKeyFile != "" {
+		if r.TLSCertFile == "" || r.TLSKeyFile == "" {
+			return nil, errors.New("Both a client certificate and key are required for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(r.TLSCertFile, r.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
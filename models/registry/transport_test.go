@@ -0,0 +1,178 @@
+package registry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writePEM writes a single PEM block to the file and returns its path
+func writePEM(t *testing.T, path string, blockType string, der []byte) string {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCertificate creates a self signed client certificate and key in dir and returns it with their paths
+func newClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker-registry-manager"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", der)
+	keyFile := writePEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", keyDER)
+	return cert, certFile, keyFile
+}
+
+// get requests the base route of the registry with a new client built from its settings
+func get(r Registry) error {
+	c, err := NewRegistryClient(r)
+	if err != nil {
+		return err
+	}
+	response, err := c.Get(context.Background(), r.GetURI()+"/")
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// TestTLSConfig checks that the CA bundle, client certificate and insecure settings of a registry are used by its transport
+func TestTLSConfig(t *testing.T) {
+	defer func(attempts int) { RetryAttempts = attempts }(RetryAttempts)
+	RetryAttempts = 1
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	caFile := writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", server.Certificate().Raw)
+
+	Convey("A registry with a certificate from an unknown CA should be refused by default", t, func() {
+		r, err := ParseRegistry(server.URL + "/v2")
+		So(err, ShouldBeNil)
+		So(r.TLSInsecureSkipVerify, ShouldBeFalse)
+		So(get(r), ShouldNotBeNil)
+	})
+
+	Convey("A registry should be trusted with its CA bundle", t, func() {
+		r, err := ParseRegistry(server.URL + "/v2?tls-ca=" + url.QueryEscape(caFile))
+		So(err, ShouldBeNil)
+		So(get(r), ShouldBeNil)
+	})
+
+	Convey("Certificates should only go unverified when insecure is set", t, func() {
+		r, err := ParseRegistry(server.URL + "/v2?tls-insecure=true")
+		So(err, ShouldBeNil)
+		So(get(r), ShouldBeNil)
+
+		r, err = ParseRegistry(server.URL + "/v2?tls-insecure=false")
+		So(err, ShouldBeNil)
+		So(get(r), ShouldNotBeNil)
+	})
+
+	Convey("Missing or unreadable TLS files should be an error instead of falling back to the defaults", t, func() {
+		garbage := filepath.Join(dir, "garbage.pem")
+		So(ioutil.WriteFile(garbage, []byte("not a certificate"), 0600), ShouldBeNil)
+
+		for _, query := range []string{
+			"tls-ca=" + url.QueryEscape(filepath.Join(dir, "missing.pem")),
+			"tls-ca=" + url.QueryEscape(garbage),
+			"tls-cert=" + url.QueryEscape(caFile),
+			"tls-cert=" + url.QueryEscape(garbage) + "&tls-key=" + url.QueryEscape(garbage),
+		} {
+			r, err := ParseRegistry(server.URL + "/v2?" + query)
+			So(err, ShouldBeNil)
+			_, err = r.TLSConfig()
+			So(err, ShouldNotBeNil)
+			_, err = NewRegistryClient(r)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+// TestTLSClientCertificate checks that the client certificate and key are presented to registries requiring mutual TLS
+func TestTLSClientCertificate(t *testing.T) {
+	defer func(attempts int) { RetryAttempts = attempts }(RetryAttempts)
+	RetryAttempts = 1
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clientCert, certFile, keyFile := newClientCertificate(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	caFile := writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", server.Certificate().Raw)
+
+	Convey("The client certificate and key should be loaded and presented", t, func() {
+		r, err := ParseRegistry(server.URL + "/v2?tls-ca=" + url.QueryEscape(caFile) +
+			"&tls-cert=" + url.QueryEscape(certFile) + "&tls-key=" + url.QueryEscape(keyFile))
+		So(err, ShouldBeNil)
+		config, err := r.TLSConfig()
+		So(err, ShouldBeNil)
+		So(len(config.Certificates), ShouldEqual, 1)
+		So(get(r), ShouldBeNil)
+	})
+
+	Convey("A registry requiring a client certificate should refuse requests without one", t, func() {
+		r, err := ParseRegistry(server.URL + "/v2?tls-ca=" + url.QueryEscape(caFile))
+		So(err, ShouldBeNil)
+		So(get(r), ShouldNotBeNil)
+	})
+}
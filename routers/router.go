@@ -15,6 +15,15 @@ func init() {
 	beego.Router("/registries/all/count", &controllers.RegistriesController{}, "get:GetRegistryCount")
 	beego.Router("/registries/add", &controllers.RegistriesController{}, "post:AddRegistry")
 	beego.Router("/registries/test", &controllers.RegistriesController{}, "post:TestRegistryStatus")
+	beego.Router("/registries/health", &controllers.RegistriesController{}, "get:GetHealth")
+	beego.Router("/registries/:registryName/health", &controllers.RegistriesController{}, "get:GetRegistryHealth")
+	beego.Router("/registries/:registryName/update", &controllers.RegistriesController{}, "post:UpdateRegistry")
+	beego.Router("/registries/:registryName/remove", &controllers.RegistriesController{}, "post:RemoveRegistry")
+	beego.Router("/registries/:registryName/refresh", &controllers.RegistriesController{}, "post:RefreshRegistry")
+
+	// Routers for registry notifications
+	beego.Router("/registries/notifications", &controllers.NotificationsController{}, "post:PostNotification")
+	beego.Router("/registries/:registryName/notifications", &controllers.NotificationsController{}, "post:PostNotification")
 
 	// Routers for repositories
 	beego.Router("/registries/:registryName/repositories/", &controllers.RepositoriesController{}, "get:GetRepositories")
@@ -23,11 +32,18 @@ func init() {
 
 	// Routers for tags
 	beego.Router("/registries/:registryName/repositories/*/tags", &controllers.TagsController{}, "get:GetTags")
+	beego.Router("/registries/:registryName/repositories/*/tags/page", &controllers.TagsController{}, "get:GetTagsPage")
 	beego.Router("/registries/:registryName/repositories/*/tags/:tagName/delete", &controllers.TagsController{}, "post:DeleteTags")
 
 	// Routers for images
 	beego.Router("/registries/:registryName/repositories/*/tags/:tagName/images", &controllers.ImagesController{}, "get:GetImages")
 
+	// Routers for activity
+	beego.Router("/activity", &controllers.ActivityController{}, "get:Get")
+	beego.Router("/activity/events", &controllers.ActivityController{}, "get:GetEvents")
+	beego.Router("/registries/:registryName/activity", &controllers.ActivityController{}, "get:Get")
+	beego.Router("/registries/:registryName/repositories/*/activity", &controllers.ActivityController{}, "get:Get")
+
 	// Routers for logs
 	beego.Router("/logs", &controllers.SettingsController{}, "get:GetLogs")
 	beego.Router("/logs/clear", &controllers.SettingsController{}, "post:ClearLogs")
@@ -38,4 +54,5 @@ func init() {
 	// Routers for settings
 	beego.Router("/settings", &controllers.SettingsController{}, "get:Get")
 	beego.Router("/settings/stats", &controllers.SettingsController{}, "get:GetLiveStatistics")
+	beego.Router("/settings/docker-config", &controllers.SettingsController{}, "post:ImportDockerConfig")
 }
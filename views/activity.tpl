@@ -0,0 +1,135 @@
+{{template "base/base.html" .}}
+{{define "body"}}
+  <div class="right-content-container">
+    <div class="header">
+      <ol class="breadcrumb">
+        <li><a href="/">Home</a></li>
+        {{if .registryName}}
+        <li><a href="/registries">Registries</a></li>
+        <li><a class="registry-name" href="/registries/{{.registryName}}/repositories">{{.registryName}}</a></li>
+        {{if .repositoryName}}
+        <li><a href="/registries/{{.registryName}}/repositories">Repositories</a></li>
+        <li><a class="registry-name" href="/registries/{{.registryName}}/repositories/{{.repositoryNameEncode}}/tags">{{.repositoryName}}</a></li>
+        {{end}}
+        {{end}}
+        <li class="active">Activity</li>
+      </ol>
+    </div>
+    <div class="content-block white-bg">
+      <div class="row">
+        <h1>{{if .repositoryName}}{{.repositoryName}} Activity{{else if .registryName}}{{.registryName}} Activity{{else}}Activity{{end}}</h1>
+        <hr>
+      </div>
+      <div class="row">
+        <form id="activity-filter" class="form-inline" style="margin-bottom:15px;">
+          {{if not .registryName}}
+          <select class="form-control" name="registry">
+            <option value="">All registries</option>
+            {{range .registries}}<option value="{{.Alias}}">{{.Alias}}</option>{{end}}
+          </select>
+          {{else}}
+          <input type="hidden" name="registry" value="{{.registryName}}">
+          {{end}}
+          {{if not .repositoryName}}
+          <input type="text" class="form-control" name="repository" placeholder="Repository">
+          {{else}}
+          <input type="hidden" name="repository" value="{{.repositoryName}}">
+          {{end}}
+          <input type="text" class="form-control" name="tag" placeholder="Tag">
+          <input type="text" class="form-control" name="actor" placeholder="Actor">
+          <select class="form-control" name="action">
+            <option value="">All actions</option>
+            {{range .actions}}<option value="{{.}}" class="text-capitalize">{{.}}</option>{{end}}
+          </select>
+          <select class="form-control" name="since">
+            <option value="">Any time</option>
+            <option value="1h">Last hour</option>
+            <option value="24h">Last 24 hours</option>
+            <option value="168h">Last 7 days</option>
+          </select>
+          <button type="submit" class="btn btn-default"><i class="fa fa-filter"></i> Filter</button>
+        </form>
+      </div>
+      <div class="row">
+        <table id="datatable" class="table table-striped table-bordered" cellspacing="0" width="100%">
+          <thead>
+            <th>Time:</th>
+            <th>Action:</th>
+            <th>Registry:</th>
+            <th>Repository:</th>
+            <th>Tag:</th>
+            <th>Digest:</th>
+            <th>Actor:</th>
+          </thead>
+          <tfoot>
+            <th>Time:</th>
+            <th>Action:</th>
+            <th>Registry:</th>
+            <th>Repository:</th>
+            <th>Tag:</th>
+            <th>Digest:</th>
+            <th>Actor:</th>
+          </tfoot>
+        </table>
+      </div>
+    </div>
+  </div>
+
+  <script>
+  $(document).ready(function() {
+      var labels = {push: "label-success", pull: "label-info", delete: "label-danger"};
+      var text = function(data) {
+        return $("<div>").text(data || "").html();
+      };
+      var table = $('#datatable').DataTable( {
+          "ajax": {
+              url: "/activity/events",
+              data: function() {
+                var params = {};
+                $.each($("#activity-filter").serializeArray(), function(i, field) {
+                  if (field.value) {
+                    params[field.name] = field.value;
+                  }
+                });
+                return params;
+              },
+              dataSrc: "events",
+          },
+          "order": [[ 0, "desc" ]],
+          "pageLength": 25,
+          "columns": [
+            { "data": "time", "render": function(data, type) {
+                return type === "display" ? new Date(data).toLocaleString() : data;
+            }},
+            { "data": "action", "render": function(data, type, row) {
+                if (type !== "display") {
+                  return data;
+                }
+                var title = row.source === "manager" ? "Made from the registry manager" : "Notified by the registry";
+                return "<span class='label " + (labels[data] || "label-default") + " text-capitalize' title='" + title + "'>" + data + "</span>";
+            }},
+            { "data": "alias", "render": text },
+            { "data": "repository", "render": function(data, type, row) {
+                if (type !== "display") {
+                  return data;
+                }
+                return $("<a>").attr("href", "/registries/" + row.alias + "/repositories/" + encodeURIComponent(data) + "/activity").text(data)[0].outerHTML;
+            }},
+            { "data": "tag", "defaultContent": "", "render": text },
+            { "data": "digest", "defaultContent": "", "render": function(data, type) {
+                if (type !== "display" || !data) {
+                  return data || "";
+                }
+                return $("<code>").attr("title", data).text(data.substring(0, 19))[0].outerHTML;
+            }},
+            { "data": "actor", "defaultContent": "", "render": text }
+         ],
+      } );
+
+      $("#activity-filter").submit(function(e){
+        e.preventDefault();
+        table.ajax.reload();
+      });
+  });
+  </script>
+{{end}}
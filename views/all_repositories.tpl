@@ -13,17 +13,26 @@
         <h1>All Repositories</h1>
         <hr>
       </div>
+      <div class="row">
+        <form class="form-inline" method="get" action="/registries/all/repositories" style="margin-bottom:15px;">
+          <input type="text" class="form-control" name="selector" value="{{.selector}}" placeholder="Filter registries by labels, ex: env=prod">
+          <button type="submit" class="btn btn-default"><i class="fa fa-filter"></i> Filter</button>
+          {{if .selector}}<a href="/registries/all/repositories" class="btn btn-link">Clear</a>{{end}}
+        </form>
+      </div>
       <div class="row">
         <table id="datatable" class="table table-striped table-bordered" cellspacing="0" width="100%">
           <thead>
             <th>Repository:</th>
             <th>Registry:</th>
             <th>Tags:</th>
+            <th>Refreshed:</th>
           </thead>
           <tfoot>
             <th>Repository:</th>
             <th>Registry:</th>
             <th>Tags:</th>
+            <th>Refreshed:</th>
           </tfoot>
           <tbody>
             {{range $key, $repository := .repositories}}
@@ -32,6 +41,7 @@
                 <td><a href=/registries/{{$repo.Registry}}/repositories/{{$repo.EncodedURI}}/tags>{{$repo.Name}}</span></td>
                 <td>{{$repo.Registry}}</td>
                 <td>{{$repo.TagCount}}</td>
+                <td data-order="{{$repo.Refreshed.Unix}}">{{if $repo.RefreshedAgo}}{{$repo.RefreshedAgo}}{{else}}-{{end}}</td>
               </tr>
               {{end}}
             {{end}}
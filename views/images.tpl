@@ -15,11 +15,24 @@
       </ol>
     </div>
     <div class="content-block white-bg">
+      {{if .tagInfo.Platforms}}
+      <div class="row" style="margin-bottom:15px;">
+        <span>Platform:</span>
+        <div class="btn-group" role="group" aria-label="Platforms">
+          {{range $key, $p := .tagInfo.Platforms}}
+          <a href="?platform={{$p.Name}}" class="btn btn-sm {{if eq $p.Name $.platform}}btn-primary{{else}}btn-default{{end}}">{{$p.Name}}</a>
+          {{end}}
+        </div>
+      </div>
+      {{end}}
       <div class="row">
         <ul class="nav nav-tabs" role="tablist">
           <li role="presentation" class="active"><a href="#overview" aria-controls="overview" role="tab" data-toggle="tab">Overview</a></li>
           <li role="presentation"><a href="#stages" aria-controls="stages" role="tab" data-toggle="tab">Dockerfile</a></li>
           <li role="presentation"><a href="#layers" aria-controls="layers" role="tab" data-toggle="tab">Layers</a></li>
+          {{if .tagInfo.Platforms}}
+          <li role="presentation"><a href="#platforms" aria-controls="platforms" role="tab" data-toggle="tab">Platforms</a></li>
+          {{end}}
           <li role="presentation"><a href="#private-registry" aria-controls="private-registry" role="tab" data-toggle="tab">Private Registry</a></li>
           <li role="presentation"><a href="#dockerhub" aria-controls="dockerhub" role="tab" data-toggle="tab">Dockerhub</a></li>
         </ul>
@@ -32,6 +45,7 @@
                   <ul>
                     <li>Operating System: {{.os}}</li>
                     <li>Architecture: {{.arch}}</li>
+                    <li>Digest: <code>{{.digest}}</code></li>
                     <li>Layers: {{.tagInfo.Layers}}</li>
                     <li>Size: {{.tagInfo.Size}}</li>
                   </ul>
@@ -96,23 +110,45 @@
                   <td>{{$index}}</td>
                   <td>{{$layer.BlobSum}}</td>
                   <td>{{$layer.SizeStr}}</td>
-                  <td><a href="{{$.registry.Scheme}}://{{$.registry.Name}}:{{$.registry.Port}}/{{$.registry.Version}}/{{$.repositoryName}}/blobs/{{$layer.BlobSum}}" download class="btn btn-sm btn-success"><span class="glyphicon glyphicon-download-alt"></span> Download</a></td>
+                  <td><a href="{{$.registry.GetURI}}/{{$.repositoryName}}/blobs/{{$layer.BlobSum}}" download class="btn btn-sm btn-success"><span class="glyphicon glyphicon-download-alt"></span> Download</a></td>
+                </tr>
+                {{end}}
+              </tbody>
+            </table>
+          </div>
+          {{if .tagInfo.Platforms}}
+          <div role="tabpanel" class="tab-pane" id="platforms">
+            <table class="table">
+              <thead>
+                <th>Platform:</th>
+                <th>Digest:</th>
+                <th>Size:</th>
+                <th>Layers:</th>
+              </thead>
+              <tbody>
+                {{range $key, $p := .tagInfo.Platforms}}
+                <tr>
+                  <td><a href="?platform={{$p.Name}}">{{$p.Name}}</a></td>
+                  <td>{{$p.Digest}}</td>
+                  <td>{{$p.Size}}</td>
+                  <td>{{$p.Layers}}</td>
                 </tr>
                 {{end}}
               </tbody>
             </table>
           </div>
+          {{end}}
           <div role="tabpanel" class="tab-pane" id="private-registry">
             <div>Push to {{.tagInfo.Name}}:</div>
             <ol>
               <li><code>cd $PROJECTNAME</code></li>
-              <li><code>docker build --rm -t {{.registry.Name}}:{{.registry.Port}}/{{.repositoryName}}:{{.tagInfo.Name}} .</code></li>
-              <li><code>docker push {{.registry.Name}}:{{.registry.Port}}/{{.repositoryName}} .</code></li>
+              <li><code>docker build --rm -t {{.registry.Host}}/{{.repositoryName}}:{{.tagInfo.Name}} .</code></li>
+              <li><code>docker push {{.registry.Host}}/{{.repositoryName}} .</code></li>
             </ol>
 
             <div>Download {{.tagInfo.Name}}:</div>
             <div>Push to another private registry {{.tagInfo.Name}}:</div>
-            <a href="{{$.registry.Scheme}}://{{$.registry.Name}}:{{$.registry.Port}}/{{$.registry.Version}}/{{$.repositoryName}}/{{$.tagInfo.Name}}" download><i class="fa fa-download"></i></a>
+            <a href="{{$.registry.GetURI}}/{{$.repositoryName}}/manifests/{{$.tagInfo.Name}}" download><i class="fa fa-download"></i></a>
           </div>
           <div role="tabpanel" class="tab-pane" id="dockerhub">
             <h3>Push to Dockerhub</h3>
@@ -4,22 +4,89 @@
     <div class="modal-content">
       <div class="modal-header">
         <button type="button" class="close" data-dismiss="modal">&times;</button>
-        <h4 class="modal-title">Add new registry</h4>
+        <h4 class="modal-title" id="registry-form-title">Add new registry</h4>
       </div>
       <div class="modal-body">
         <form id="registry-form" action="/registries/add" method="post">
+            <fieldset class="form-group">
+              <label for="display-name-input">Display Name</label>
+              <input type="text" class="form-control" id="display-name-input" name="display_name" placeholder="optional, ex: Production">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="alias-input">Alias</label>
+              <input type="text" class="form-control" id="alias-input" name="alias" placeholder="optional, used in links, ex: prod">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="labels-input">Labels</label>
+              <input type="text" class="form-control" id="labels-input" name="labels" placeholder="optional, comma separated, ex: env=prod, region=eu">
+            </fieldset>
             <fieldset class="form-group">
               <label for="host-input">Host</label>
               <input type="text" class="form-control" id="host-input" name="host" placeholder="ex: 192.168.1.1 or testhost.com">
             </fieldset>
             <fieldset class="form-group">
               <label for="port-input">Port</label>
-              <input type="text" class="form-control" id="port-input" name="port" placeholder="ex: 5000">
+              <input type="text" class="form-control" id="port-input" name="port" placeholder="ex: 5000, defaults to 443 for https and 80 for http">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="path-input">Path</label>
+              <input type="text" class="form-control" id="path-input" name="path" placeholder="optional, for registries served under a path prefix, ex: /registry">
             </fieldset>
             <fieldset class="form-group">
               <label for="scheme-input">Scheme</label>
               <input type="text" class="form-control" id="scheme-input" name="scheme" placeholder="ex: https">
             </fieldset>
+            <fieldset class="form-group">
+              <label for="kind-input">Kind</label>
+              <select class="form-control" id="kind-input" name="kind">
+                <option value="">Private, repositories are listed from the catalog</option>
+                <option value="public">Public, read only with watched repositories (Docker Hub is always public)</option>
+              </select>
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="repositories-input">Watched Repositories</label>
+              <textarea class="form-control" id="repositories-input" name="repositories" rows="2" placeholder="for public registries, comma or newline separated, ex: alpine, grafana/grafana"></textarea>
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="username-input">Username</label>
+              <input type="text" class="form-control" id="username-input" name="username" placeholder="optional" autocomplete="off">
+              <small class="help-block edit-only" style="display:none">Leave the username and password empty to keep the saved credentials.</small>
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="password-input">Password</label>
+              <input type="password" class="form-control" id="password-input" name="password" placeholder="optional" autocomplete="new-password">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="tls-ca-input">CA Bundle</label>
+              <input type="text" class="form-control" id="tls-ca-input" name="tls_ca" placeholder="optional, ex: /etc/ssl/certs/corp-ca.pem">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="tls-cert-input">Client Certificate</label>
+              <input type="text" class="form-control" id="tls-cert-input" name="tls_cert" placeholder="optional, ex: /etc/docker/certs.d/client.cert">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="tls-key-input">Client Key</label>
+              <input type="text" class="form-control" id="tls-key-input" name="tls_key" placeholder="optional, ex: /etc/docker/certs.d/client.key">
+            </fieldset>
+            <div class="checkbox">
+              <label><input type="checkbox" id="tls-insecure-input" name="tls_insecure" value="true"> Skip TLS certificate verification (insecure)</label>
+            </div>
+            <fieldset class="form-group">
+              <label for="proxy-input">Proxy</label>
+              <input type="text" class="form-control" id="proxy-input" name="proxy" placeholder="optional, ex: http://proxy.corp:3128 or socks5://proxy.corp:1080, direct to never use a proxy">
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="proxy-username-input">Proxy Username</label>
+              <input type="text" class="form-control" id="proxy-username-input" name="proxy_username" placeholder="optional" autocomplete="off">
+              <small class="help-block edit-only" style="display:none">Leave the proxy username and password empty to keep the saved proxy credentials.</small>
+            </fieldset>
+            <fieldset class="form-group">
+              <label for="proxy-password-input">Proxy Password</label>
+              <input type="password" class="form-control" id="proxy-password-input" name="proxy_password" placeholder="optional" autocomplete="new-password">
+            </fieldset>
+            <div class="checkbox edit-only" style="display:none">
+              <label><input type="checkbox" id="clear-credentials-input" name="clear_credentials" value="true"> Remove the saved registry and proxy credentials</label>
+            </div>
             <div class="modal-footer">
               <button style="float:left;" type="button" id="test" class="btn btn-warning">Test</button>
               <input type="submit" class="btn btn-success">
@@ -32,6 +99,60 @@
 </div>
 
 <script>
+// The registry boxes are rendered after this template, so the handlers are delegated from the document
+// Reset the form to add a new registry
+$(document).on("click", ".add-new", function() {
+  $("#registry-form")[0].reset();
+  $("#registry-form").attr("action", "/registries/add");
+  $("#registry-form-title").text("Add new registry");
+  $("#registry-form .edit-only").hide();
+});
+
+// Fill the form with the settings of an existing registry to edit it
+$(document).on("click", ".edit-registry", function(e) {
+  e.preventDefault();
+  var r = $(this).data();
+  $("#registry-form")[0].reset();
+  $("#registry-form").attr("action", "/registries/" + encodeURIComponent(r.alias) + "/update");
+  $("#registry-form-title").text("Edit registry " + r.alias);
+  $("#alias-input").val(r.alias);
+  $("#display-name-input").val(r.displayName);
+  $("#labels-input").val(r.labels);
+  $("#host-input").val(r.host);
+  $("#port-input").val(r.port);
+  $("#path-input").val(r.path);
+  $("#scheme-input").val(r.scheme);
+  $("#kind-input").val(r.kind);
+  $("#repositories-input").val(r.repositories);
+  $("#tls-ca-input").val(r.tlsCa);
+  $("#tls-cert-input").val(r.tlsCert);
+  $("#tls-key-input").val(r.tlsKey);
+  $("#tls-insecure-input").prop("checked", r.tlsInsecure === true);
+  $("#proxy-input").val(r.proxy);
+  $("#registry-form .edit-only").show();
+  $("#new-registry-modal").modal("show");
+});
+
+// Remove a registry after confirming
+$(document).on("click", ".remove-registry", function(e) {
+  e.preventDefault();
+  var name = String($(this).data("alias"));
+  if (!confirm("Remove the registry " + name + "?")) {
+    return;
+  }
+  $.ajax({
+      type: 'POST',
+      url: '/registries/' + encodeURIComponent(name) + '/remove',
+      dataType: 'json',
+      success: function () {
+        location.reload();
+      },
+      error: function () {
+        alert("We were unable to remove the registry " + name + ".");
+      }
+  });
+});
+
 $("#test").click(function() {
    var data = $('#registry-form').serialize();
   $.ajax({
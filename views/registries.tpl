@@ -11,15 +11,21 @@
 
     <div class = "content-block-empty">
       <div class="col-lg-12">
+        <form class="form-inline" method="get" action="/registries" style="margin-bottom:15px;">
+          <input type="text" class="form-control" name="selector" value="{{.selector}}" placeholder="Filter by labels, ex: env=prod,region!=eu">
+          <button type="submit" class="btn btn-default"><i class="fa fa-filter"></i> Filter</button>
+          {{if .selector}}<a href="/registries" class="btn btn-link">Clear</a> <a href="/registries/all/repositories?selector={{.selector}}" class="btn btn-link">Repositories</a>{{end}}
+        </form>
         <ul class="boxes">
           {{range $key, $registry := .registries}}
           <li>
-          <a href="/registries/{{$registry.Name}}/repositories">
+          <a href="/registries/{{$registry.Alias}}/repositories">
             <div class="white-bg box col-lg-4 col-md-6 col-sm-12 col-xs-12">
               <div class="col-lg-12">
                 <div class="box-container">
                   <div class="box-header">
-                    <h2>{{$registry.Name}}<small> {{$registry.IP}}</small></h2>
+                    <h2>{{if $registry.DisplayName}}{{$registry.DisplayName}}{{else}}{{$registry.Alias}}{{end}}<small> {{$registry.Host}}{{$registry.Path}}</small></h2>
+                    {{range $registry.LabelList}}<span class="label label-default registry-label" data-selector="{{.}}">{{.}}</span> {{end}}
                   </div>
                   <div class="box-body">
                     <div class="info">
@@ -42,7 +48,39 @@
                     </div>
                   </div>
                   <div class="box-footer">
-                    <span class="label label-success text-capitalize">{{$registry.Status}}</span>
+                    <span class="label {{if eq $registry.Status "available"}}label-success{{else if eq $registry.Status "degraded"}}label-warning{{else}}label-danger{{end}} text-capitalize">{{$registry.Status}}</span>
+                    {{if $registry.IsPublic}}<span class="label label-info">Public</span>{{end}}
+                    {{with $registry.RateLimit}}{{if .Paused}}
+                    <small class="text-warning">rate limited until {{.PausedUntil.Format "15:04:05"}}</small>
+                    {{else if .Known}}
+                    <small class="text-muted" title="Rate limit reported by the registry">{{.Remaining}}/{{.Limit}} pulls left</small>
+                    {{end}}{{end}}
+                    {{if $registry.Capabilities.Known}}
+                    <small class="text-muted" title="Since {{$registry.Capabilities.Checked.Format "2006-01-02 15:04:05"}}">
+                      {{if $registry.Capabilities.APIVersion}}{{$registry.Capabilities.APIVersion}}{{end}}
+                      {{if not $registry.Capabilities.Catalog}}&middot; no catalog{{end}}
+                      {{if not $registry.Capabilities.Delete}}&middot; read only{{end}}
+                      {{if $registry.Capabilities.Referrers}}&middot; referrers{{end}}
+                    </small>
+                    {{end}}
+                    {{with index $.health $registry.ID}}{{if not .LastChecked.IsZero}}
+                    <small class="text-muted" title="Last checked {{.LastChecked.Format "2006-01-02 15:04:05"}}{{if .LastError}}: {{.LastError}}{{end}}">
+                      {{.LatencyStr}} &middot; {{printf "%.1f" .Uptime24h}}% 24h &middot; {{printf "%.1f" .Uptime7d}}% 7d
+                    </small>
+                    {{end}}{{end}}
+                    {{if $.indexing}}{{with index $.indexed $registry.ID}}
+                    <small class="{{if .Error}}text-warning{{else}}text-muted{{end}}" title="Refreshed {{.Refreshed.Format "2006-01-02 15:04:05"}}, {{.Fetched}} manifests fetched and {{.Revalidated}} unchanged{{if .Error}}: {{.Error}}{{end}}">&middot; refreshed {{.RefreshedAgo}}</small>
+                    {{else}}
+                    <small class="text-muted">&middot; not indexed yet</small>
+                    {{end}}{{end}}
+                    <span class="pull-right">
+                      <button type="button" class="btn btn-xs btn-default edit-registry" title="Edit"
+                        data-alias="{{$registry.Alias}}" data-host="{{$registry.Name}}" data-display-name="{{$registry.DisplayName}}" data-labels="{{range $i, $label := $registry.LabelList}}{{if $i}}, {{end}}{{$label}}{{end}}" data-port="{{$registry.Port}}" data-path="{{$registry.Path}}" data-scheme="{{$registry.Scheme}}"
+                        data-kind="{{$registry.Kind}}" data-repositories="{{range $i, $repo := $registry.Repositories}}{{if $i}}, {{end}}{{$repo}}{{end}}"
+                        data-tls-ca="{{$registry.TLSCAFile}}" data-tls-cert="{{$registry.TLSCertFile}}" data-tls-key="{{$registry.TLSKeyFile}}"
+                        data-tls-insecure="{{$registry.TLSInsecureSkipVerify}}" data-proxy="{{$registry.Proxy}}"><i class="fa fa-pencil"></i></button>
+                      <button type="button" class="btn btn-xs btn-danger remove-registry" title="Remove" data-alias="{{$registry.Alias}}"><i class="fa fa-trash"></i></button>
+                    </span>
                   </div>
                 </div>
               </div>
@@ -66,4 +104,11 @@
     </div>
   </div>
 
+<script>
+// Labels are inside the registry links, so filter by the label instead of following the link
+$(document).on("click", ".registry-label", function(e) {
+  e.preventDefault();
+  window.location = "/registries?selector=" + encodeURIComponent($(this).data("selector"));
+});
+</script>
 {{end}}
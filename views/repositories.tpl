@@ -12,19 +12,31 @@
     <div class="content-block white-bg">
       <div class="row">
         <h1>Repositories</h1>
+        {{if .indexing}}
+        <p class="text-muted">
+          {{with .indexed}}<span title="{{if .Error}}{{.Error}}{{end}}">Refreshed {{.RefreshedAgo}}{{if .Error}} with errors{{end}}</span>{{else}}Not indexed yet, showing the registry live{{end}}
+          <button id="refresh-registry" type="button" class="btn btn-xs btn-default"><i class="fa fa-refresh"></i> Refresh</button>
+        </p>
+        {{end}}
+        <a href="/registries/{{.registryName}}/activity" class="btn btn-xs btn-default"><i class="fa fa-exchange"></i> Activity</a>
         <hr>
       </div>
+      {{if not .canListCatalog}}
+      <div class="alert alert-warning">This registry does not allow its catalog to be listed, so its repositories can't be shown.</div>
+      {{end}}
       <div class="row">
         <table id="datatable" class="table table-striped table-bordered" cellspacing="0" width="100%">
           <thead>
             <th>ID:</th>
             <th>Repository Name:</th>
             <th>Tags</th>
+            <th>Refreshed</th>
           </thead>
           <tfoot>
             <th>ID:</th>
             <th>Repository Name:</th>
             <th>Tags</th>
+            <th>Refreshed</th>
           </tfoot>
           <tbody>
             {{range $key, $repository := .repositories}}
@@ -32,6 +44,7 @@
               <td>{{$key}}</td>
               <td><a href=/registries/{{$.registryName}}/repositories/{{$repository.EncodedURI}}/tags>{{$repository.Name}}</span></td>
               <td>{{$repository.TagCount}}</td>
+              <td data-order="{{$repository.Refreshed.Unix}}">{{if $repository.RefreshedAgo}}{{$repository.RefreshedAgo}}{{else}}-{{end}}</td>
             </tr>
             {{end}}
           </tbody>
@@ -42,6 +55,17 @@
 
   <script>
   $(document).ready(function() {
+      $("#refresh-registry").click(function(e){
+        var $btn = $(this).prop("disabled", true);
+        $.ajax({type: "POST",
+            url: "/registries/{{.registryName}}/refresh",
+            success:function(result){
+              $btn.html("<i class='fa fa-refresh fa-spin'></i> Refreshing");
+            },
+            error:function(xhr){
+              $btn.prop("disabled", false);
+            }});
+      });
       $('#datatable').DataTable( {
           "order": [[ 1, "asc" ]],
           "pageLength": 25
@@ -46,6 +46,22 @@
         </div>
       </div>
     </div>
+    <div class="row content-block white-bg" id="import">
+      <div class="row">
+        <h1>Import Registries</h1>
+        <hr>
+      </div>
+      <div class="row">
+        <div class="col-lg-12">
+          <p>Add the registries of a docker <code>config.json</code>, e.g <code>~/.docker/config.json</code>, with their credentials. Credential helpers are run from the PATH of the server.</p>
+          <form id="docker-config-form" class="form-inline" enctype="multipart/form-data">
+            <input type="file" class="form-control" name="docker_config" accept=".json,application/json">
+            <button type="submit" class="btn btn-default"><i class="fa fa-upload"></i> Import</button>
+          </form>
+          <ul id="docker-config-results" class="list-unstyled" style="margin-top:10px;"></ul>
+        </div>
+      </div>
+    </div>
     <div class="row content-block white-bg">
       <div class="row">
         <h1>Upgrade</h1>
@@ -195,6 +211,31 @@
        ],
     } );
 
+    $("#docker-config-form").submit(function(e){
+      e.preventDefault();
+      $("#docker-config-results").empty();
+      $.ajax({type: "POST",
+          url: "/settings/docker-config",
+          data: new FormData(this),
+          processData: false,
+          contentType: false,
+          dataType: "json",
+          success:function(result){
+            $.each(result.registries, function(i, r) {
+              var $li = $("<li>");
+              if (r.error) {
+                $li.addClass("text-danger").text(r.server_url + ": " + r.error);
+              } else {
+                $li.addClass("text-success").text(r.server_url + " " + (r.updated ? "updated" : "added") + " as " + r.alias);
+              }
+              $li.appendTo("#docker-config-results");
+            });
+          },
+          error:function(xhr){
+            var msg = xhr.responseJSON && xhr.responseJSON.error ? xhr.responseJSON.error : xhr.statusText;
+            $("<li class='text-danger'>").text(msg).appendTo("#docker-config-results");
+          }});
+    });
     $("#clear-logs").click(function(e){
       e.preventDefault();
       $.ajax({type: "POST",
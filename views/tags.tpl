@@ -14,6 +14,8 @@
     <div class="content-block white-bg">
       <div class="row">
         <h1>{{.repositoryName}}</h1>
+        <p id="tags-refreshed" class="text-muted" style="display:none;"></p>
+        <a href="/registries/{{.registryName}}/repositories/{{.repositoryNameEncode}}/activity" class="btn btn-xs btn-default"><i class="fa fa-exchange"></i> Activity</a>
         <hr>
       </div>
       <div class="row">
@@ -38,18 +40,14 @@
                 </tr>
              </tfoot>
              <tbody>
-               {{range $key, $tag := .tags}}
-              <tr data-tag-name="{{$tag.Name}}">
-                <td></td>
-                <td ><a href=/registries/{{$.registryName}}/repositories/{{$.repositoryName}}/tags/{{$tag.Name}}/images>{{$tag.Name}}</span></td>
-                <td data-order="{{$tag.UpdatedTimeUnix}}">{{$tag.TimeAgo}}</td>
-                <td>{{$tag.Size}}</td>
-                <td>{{$tag.Layers}}</td>
-              </tr>
-              {{end}}
             </tbody>
         </table>
+        <p id="tags-loading"><i class="fa fa-spinner fa-spin"></i> Loading tags...</p>
+        {{if .canDelete}}
         <p><button class="btn btn-danger">Delete</button></p>
+        {{else}}
+        <p><button class="btn btn-danger" disabled title="Deletes are not enabled on this registry">Delete</button> <small class="text-muted">Deletes are not enabled on this registry.</small></p>
+        {{end}}
 
 </form>
       </div>
@@ -116,6 +114,48 @@
         }
      });
 
+     // Load the tags a page at a time so large repositories show up without waiting for every tag
+     function loadTags(last) {
+        $.ajax({
+          type: "GET",
+          url: "/registries/{{.registryName}}/repositories/{{.repositoryNameEncode}}/tags/page",
+          data: {n: {{.tagPageSize}}, last: last},
+          dataType: "json",
+          success: function(data) {
+            $.each(data.tags || [], function(index, tag) {
+              var $row = $("<tr>").attr("data-tag-name", tag.Name).append(
+                $("<td>"),
+                $("<td>").append($("<a>").attr("href", "/registries/{{.registryName}}/repositories/{{.repositoryName}}/tags/" + tag.Name + "/images").text(tag.Name)).append(
+                  $.map(tag.Platforms || [], function(platform) {
+                    return [" ", $("<span class='label label-default'>").text(platform.Name)[0]];
+                  })
+                ),
+                $("<td>").attr("data-order", tag.UpdatedTimeUnix).text(tag.TimeAgo),
+                $("<td>").text(tag.Size),
+                $("<td>").text(tag.Layers)
+              );
+              table.row.add($row[0]);
+            });
+            table.draw(false);
+
+            if (data.error) {
+              $("#tags-loading").html("<div class='alert alert-danger'><strong>Failure!</strong> We were unable to load all of the tags: " + $("<span>").text(data.error).html() + "</div>");
+            } else if (data.next) {
+              loadTags(data.next);
+            } else {
+              $("#tags-loading").remove();
+            }
+            if (data.refreshed) {
+              $("#tags-refreshed").text("Refreshed " + data.refreshed).show();
+            }
+          },
+          error: function() {
+            $("#tags-loading").html("<div class='alert alert-danger'><strong>Failure!</strong> We were unable to load the tags from the registry.</div>");
+          }
+        });
+     }
+     loadTags("");
+
      // Handle click on checkbox
      $('#datatable tbody').on('click', 'input[type="checkbox"]', function(e){
         var $row = $(this).closest('tr');
@@ -177,6 +217,10 @@
      // Handle form submission event
      $('#delete-tags').on('submit', function(e){
         var form = this;
+        {{if not .canDelete}}
+        e.preventDefault();
+        return;
+        {{end}}
 
         var x = document.getElementsByClassName("selected");
         $(x).each(function(index) {
@@ -190,8 +234,8 @@
                   window.setTimeout(function() { $(".alert").alert('close'); }, 5000);
                   $("tr[data-tag-name="+tagName+"]").remove();
             },
-            error: function() {
-                  $("#delete-tags").append("<div class='alert alert-danger'><a href='#' class='close' data-dismiss='alert' aria-label='close'>&times;</a> <strong>Failure!</strong> We were unable to delete "+tagName+" from the registry. Make sure the delete option is enabled on your registry!</div>");
+            error: function(xhr) {
+                  $("#delete-tags").append("<div class='alert alert-danger'><a href='#' class='close' data-dismiss='alert' aria-label='close'>&times;</a> <strong>Failure!</strong> We were unable to delete "+tagName+" from the registry. " + $("<span>").text(xhr.responseText).html() + "</div>");
                   $(".alert").alert();
                   window.setTimeout(function() { $(".alert").alert('close'); }, 5000);
             }